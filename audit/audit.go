@@ -0,0 +1,78 @@
+// Package audit keeps a trail of security sensitive actions, such as password
+// changes and resets. Every action is a line of its own:
+//
+//	2026-10-18T14:03:11Z alice@203.0.113.7 password-reset bob
+//
+// giving when it happened, who did it and from where, what they did and who
+// they did it to. Lines go to standard output until a file is opened.
+package audit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	mutex  sync.Mutex
+	output io.Writer = os.Stdout
+
+	// The file opened by Open, if it was
+	file *os.File
+
+	// Replaced by tests
+	now = time.Now
+)
+
+// Actions that are audited
+const (
+	PasswordChange       = "password-change"
+	PasswordChangeFailed = "password-change-failed"
+	PasswordReset        = "password-reset"
+)
+
+// Open appends the trail to the given file from now on, creating it if it
+// doesn't exist
+func Open(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	setOutput(f, f)
+	return nil
+}
+
+// SetOutput sends the trail to w from now on
+func SetOutput(w io.Writer) {
+	setOutput(w, nil)
+}
+
+func setOutput(w io.Writer, f *os.File) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if file != nil {
+		file.Close()
+	}
+
+	output = w
+	file = f
+}
+
+// Log records that actor, connected from addr, took the action on target
+func Log(actor string, addr string, action string, target string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	line := fmt.Sprintf("%s %s@%s %s %s\n", now().UTC().Format(time.RFC3339), actor, addr, action, target)
+
+	if _, err := io.WriteString(output, line); err != nil {
+		fmt.Println("Unable to write to the audit trail:", err, line)
+	}
+}
+
+// vim: nocindent
@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Log(t *testing.T) {
+	var buffer bytes.Buffer
+	SetOutput(&buffer)
+	defer SetOutput(os.Stdout)
+
+	now = func() time.Time { return time.Date(2026, 10, 18, 14, 3, 11, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	Log("Alice", "203.0.113.7", PasswordReset, "Bob")
+	Log("Bob", "198.51.100.2", PasswordChange, "Bob")
+
+	want := "2026-10-18T14:03:11Z Alice@203.0.113.7 password-reset Bob\n" +
+		"2026-10-18T14:03:11Z Bob@198.51.100.2 password-change Bob\n"
+
+	if got := buffer.String(); got != want {
+		t.Errorf("Log() wrote %q, want %q", got, want)
+	}
+}
+
+func Test_Open(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetOutput(os.Stdout)
+
+	path := filepath.Join(dir, "audit.log")
+
+	// Reopening appends to what's already there
+	for i := 0; i < 2; i++ {
+		if err := Open(path); err != nil {
+			t.Fatalf("Open() failed: %v", err)
+		}
+
+		Log("Alice", "::1", PasswordChange, "Alice")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := bytes.Count(data, []byte("\n")); lines != 2 {
+		t.Errorf("The trail has %v lines, want 2: %q", lines, data)
+	}
+}
+
+// vim: nocindent
@@ -0,0 +1,274 @@
+// kmud-replay plays back a connection recorded by the server (see the
+// recorder package).
+//
+// By default the server's side of the recording is written to the terminal,
+// with telnet codes removed and MCCP compression undone, so that it looks just
+// like it did to the client:
+//
+//	kmud-replay recordings/20140101-120000.000000000-10.0.0.1_5312.kmudrec
+//
+// With -addr the client's side of the recording is sent to a running server
+// instead, with the same timing, and the server's responses are written to the
+// terminal. This makes it possible to reproduce a bug report exactly, or to
+// run a recorded session against a test server as a regression test.
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"flag"
+	"fmt"
+	"github.com/Cristofori/kmud/recorder"
+	"github.com/Cristofori/kmud/telnet"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+)
+
+var speed = flag.Float64("speed", 1, "Playback speed, 0 plays everything back without any delays")
+var addr = flag.String("addr", "", "Send the client's input to the server at this address instead of playing back the recorded output")
+var raw = flag.Bool("raw", false, "Write the bytes exactly as they were recorded, telnet codes and all")
+var linger = flag.Duration("linger", time.Second, "How long to wait for the server's last responses when using -addr")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <recording>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := replay(flag.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, "kmud-replay:", err)
+		os.Exit(1)
+	}
+}
+
+func replay(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := recorder.NewReader(file)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Replaying %s, recorded %s\n", reader.Header.RemoteAddr,
+		reader.Header.Start.Format(time.RFC1123))
+
+	if *addr == "" {
+		return playOutput(reader, newOutput(os.Stdout))
+	}
+
+	return playInput(reader, *addr, newOutput(os.Stdout))
+}
+
+// wait sleeps until the given offset into the recording has been reached,
+// scaled by the playback speed
+func wait(start time.Time, offset time.Duration) {
+	if *speed <= 0 {
+		return
+	}
+
+	target := start.Add(time.Duration(float64(offset) / *speed))
+	time.Sleep(target.Sub(time.Now()))
+}
+
+// playOutput writes the server's side of the recording
+func playOutput(reader *recorder.Reader, out io.WriteCloser) error {
+	start := time.Now()
+
+	for {
+		record, err := reader.Next()
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			out.Close()
+			return err
+		}
+
+		if record.Direction == recorder.Output {
+			wait(start, record.Offset)
+			out.Write(record.Data)
+		}
+	}
+
+	return out.Close()
+}
+
+// playInput sends the client's side of the recording to a server
+func playInput(reader *recorder.Reader, address string, out io.WriteCloser) error {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan bool)
+
+	go func() {
+		io.Copy(out, conn)
+		done <- true
+	}()
+
+	start := time.Now()
+
+	for {
+		record, err := reader.Next()
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			conn.Close()
+			return err
+		}
+
+		if record.Direction == recorder.Input {
+			wait(start, record.Offset)
+
+			if _, err := conn.Write(record.Data); err != nil {
+				return err
+			}
+		}
+	}
+
+	time.Sleep(*linger)
+	conn.Close()
+	<-done
+
+	return out.Close()
+}
+
+func newOutput(w io.Writer) io.WriteCloser {
+	if *raw {
+		return nopCloser{w}
+	}
+
+	return newDecoder(w)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// decoder turns the server's output back into what the client displayed. The
+// telnet package does the work of removing telnet codes, which leaves undoing
+// MCCP compression once the server has started it.
+type decoder struct {
+	plain      *io.PipeWriter
+	compressed *io.PipeWriter
+
+	telnetDone   chan bool
+	compressDone chan bool
+}
+
+func newDecoder(w io.Writer) *decoder {
+	var d decoder
+
+	reader, writer := io.Pipe()
+	d.plain = writer
+	d.telnetDone = make(chan bool)
+
+	t := telnet.NewTelnet(&pipeConn{reader})
+
+	go func() {
+		io.Copy(w, t)
+		d.telnetDone <- true
+	}()
+
+	return &d
+}
+
+func (self *decoder) Write(p []byte) (int, error) {
+	if self.compressed != nil {
+		return self.compressed.Write(p)
+	}
+
+	start := telnet.BuildCommand(telnet.SB, telnet.CMP2, telnet.IAC, telnet.SE)
+	index := bytes.Index(p, start)
+
+	if index == -1 {
+		return self.plain.Write(p)
+	}
+
+	index += len(start)
+	self.plain.Write(p[:index])
+
+	reader, writer := io.Pipe()
+	self.compressed = writer
+	self.compressDone = make(chan bool)
+
+	go func() {
+		if zr, err := zlib.NewReader(reader); err == nil {
+			io.Copy(self.plain, zr)
+		}
+
+		// Drain anything left over so that writes don't block
+		io.Copy(ioutil.Discard, reader)
+		self.compressDone <- true
+	}()
+
+	self.compressed.Write(p[index:])
+	return len(p), nil
+}
+
+func (self *decoder) Close() error {
+	if self.compressed != nil {
+		self.compressed.Close()
+		<-self.compressDone
+	}
+
+	self.plain.Close()
+	<-self.telnetDone
+	return nil
+}
+
+// pipeConn feeds the server's output to a telnet.Telnet as if it came from a
+// connection. The replies the telnet layer sends to negotiations are dropped.
+type pipeConn struct {
+	io.Reader
+}
+
+func (self *pipeConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (self *pipeConn) Close() error {
+	return nil
+}
+
+func (self *pipeConn) LocalAddr() net.Addr {
+	return nil
+}
+
+func (self *pipeConn) RemoteAddr() net.Addr {
+	return nil
+}
+
+func (self *pipeConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (self *pipeConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (self *pipeConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// vim: nocindent
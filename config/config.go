@@ -0,0 +1,309 @@
+// Package config collects the server's tunable settings. Every setting can be
+// given in a configuration file, through an environment variable or on the
+// command line, in increasing order of precedence:
+//
+//	# kmud.conf
+//	addr = :9945
+//	db-name = mud_staging
+//
+//	KMUD_DB_NAME=mud_staging kmud
+//
+//	kmud -config kmud.conf -db-name mud_staging
+//
+// Settings are named the same in all three places, environment variables are
+// upper cased with dashes replaced by underscores and a KMUD_ prefix. The
+// configuration file itself is given with -config or KMUD_CONFIG.
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Addr         string
+	DatabaseHost string
+	DatabaseName string
+	MaxProcs     int
+
+	RecordDir   string
+	WebAddr     string
+	SSHAddr     string
+	SSHHostKey  string
+	TLSAddr     string
+	TLSCert     string
+	TLSKey      string
+	SecureAdmin bool
+
+	InputInterval  time.Duration
+	CombatInterval time.Duration
+	RoamInterval   time.Duration
+	RegenAmount    int
+
+	ShutdownCountdown time.Duration
+
+	MinPasswordLength int
+	MinNameLength     int
+	MaxNameLength     int
+	UnicodeNames      bool
+
+	MaxConnections           int
+	MaxConnectionsPerAddress int
+	MenuIdleTimeout          time.Duration
+	IdleTimeout              time.Duration
+	IdleWarning              time.Duration
+	KeepAlive                time.Duration
+	LinkDeadTimeout          time.Duration
+
+	MaxCharacters   int
+	DeleteRetention time.Duration
+
+	LoginAttempts        int
+	AddressLoginAttempts int
+	NewUsersPerAddress   int
+	LoginLockout         time.Duration
+
+	// User that's made an admin at startup
+	Admin string
+
+	AuditLog string
+
+	// Skips password verification at login, for development only
+	DevMode bool
+
+	// Set by -print-config, the configuration should be printed instead of
+	// starting the server
+	Print bool
+}
+
+// Prefix of the environment variables that override settings
+const envPrefix = "KMUD_"
+
+func (self *Config) register(fs *flag.FlagSet) {
+	fs.StringVar(&self.Addr, "addr", ":8945", "Address to accept telnet connections on")
+	fs.StringVar(&self.DatabaseHost, "db-host", "localhost", "MongoDB server to connect to")
+	fs.StringVar(&self.DatabaseName, "db-name", "mud", "Name of the database")
+	fs.IntVar(&self.MaxProcs, "max-procs", 8, "Maximum number of CPUs to use (GOMAXPROCS)")
+
+	fs.StringVar(&self.RecordDir, "record", "", "Record every connection's raw telnet stream to this directory. Recordings contain passwords, even for TLS and SSH connections")
+	fs.StringVar(&self.WebAddr, "web", "", "Serve the web client on this address, e.g. :8080")
+	fs.StringVar(&self.SSHAddr, "ssh", "", "Accept SSH connections on this address, e.g. :2222")
+	fs.StringVar(&self.SSHHostKey, "ssh-host-key", "ssh_host_key", "SSH host key file, generated if it doesn't exist")
+	fs.StringVar(&self.TLSAddr, "tls", "", "Accept TLS encrypted telnet connections on this address, e.g. :8946")
+	fs.StringVar(&self.TLSCert, "tls-cert", "cert.pem", "TLS certificate file")
+	fs.StringVar(&self.TLSKey, "tls-key", "key.pem", "TLS private key file")
+	fs.BoolVar(&self.SecureAdmin, "secure-admin", false, "Only allow the admin menu over encrypted (TLS or SSH) connections")
+
+	fs.DurationVar(&self.InputInterval, "input-interval", 200*time.Millisecond, "Minimum time between two commands from a player")
+	fs.DurationVar(&self.CombatInterval, "combat-interval", 3*time.Second, "Time between combat rounds")
+	fs.DurationVar(&self.RoamInterval, "roam-interval", time.Second, "Time between moves of roaming NPCs")
+	fs.IntVar(&self.RegenAmount, "regen-amount", 5, "Hit points regained every tick while out of combat")
+	fs.DurationVar(&self.ShutdownCountdown, "shutdown-countdown", 30*time.Second, "Warning given to players before the server shuts down")
+
+	fs.IntVar(&self.MinPasswordLength, "min-password-length", 7, "Minimum length of new passwords")
+	fs.IntVar(&self.MinNameLength, "min-name-length", 3, "Minimum length of user and character names")
+	fs.IntVar(&self.MaxNameLength, "max-name-length", 12, "Maximum length of user and character names")
+	fs.BoolVar(&self.UnicodeNames, "unicode-names", false, "Allow letters from any script in names, not just A-Z")
+	fs.IntVar(&self.MaxConnections, "max-connections", 500, "Maximum number of connections, 0 for no limit")
+	fs.IntVar(&self.MaxConnectionsPerAddress, "max-connections-per-address", 10, "Maximum number of connections from one address, 0 for no limit")
+	fs.DurationVar(&self.MenuIdleTimeout, "menu-idle-timeout", 10*time.Minute, "Disconnect clients idle this long in the login menus, 0 to never")
+	fs.DurationVar(&self.IdleTimeout, "idle-timeout", time.Hour, "Disconnect players idle this long in the game, 0 to never")
+	fs.DurationVar(&self.IdleWarning, "idle-warning", time.Minute, "Warn idle clients this long before disconnecting them")
+	fs.DurationVar(&self.LinkDeadTimeout, "linkdead-timeout", 10*time.Minute, "How long a character stays in the game after its connection drops")
+	fs.DurationVar(&self.KeepAlive, "keepalive", time.Minute, "Interval between keepalive probes to detect dead connections, 0 to disable")
+
+	fs.IntVar(&self.MaxCharacters, "max-characters", 5, "Maximum number of characters per user, 0 for no limit")
+	fs.DurationVar(&self.DeleteRetention, "delete-retention", 30*24*time.Hour, "How long deleted users and characters can be restored before they're purged, 0 to keep them forever")
+
+	fs.IntVar(&self.LoginAttempts, "login-attempts", 5, "Failed logins before an account is locked out")
+	fs.IntVar(&self.AddressLoginAttempts, "address-login-attempts", 20, "Failed logins before an address is locked out")
+	fs.IntVar(&self.NewUsersPerAddress, "new-users-per-address", 3, "New users an address can create before it's locked out")
+	fs.DurationVar(&self.LoginLockout, "login-lockout", 15*time.Minute, "How long lockouts last, failures are forgotten after as long without any")
+
+	fs.StringVar(&self.Admin, "admin", "", "Give this user the admin role at startup")
+	fs.StringVar(&self.AuditLog, "audit-log", "audit.log", "File that password changes and resets are logged to, empty to print them instead")
+	fs.BoolVar(&self.DevMode, "dev", false, "Accept any password at login, never use this on a public server")
+
+	fs.BoolVar(&self.Print, "print-config", false, "Print the resulting configuration, in configuration file format, and exit")
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() *Config {
+	var config Config
+	config.register(flag.NewFlagSet("kmud", flag.ContinueOnError))
+	return &config
+}
+
+// Load builds the configuration from the configuration file, the environment
+// and the given command line arguments (without the program name)
+func Load(args []string) (*Config, error) {
+	var config Config
+
+	fs := flag.NewFlagSet("kmud", flag.ContinueOnError)
+	config.register(fs)
+
+	configFile := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "Configuration file")
+
+	// The command line is parsed twice, first to find the configuration file
+	// and then again so that it overrides everything else
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		file, err := os.Open(*configFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = readFile(fs, file)
+		file.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", *configFile, err)
+		}
+	}
+
+	if err := readEnv(fs, os.Getenv); err != nil {
+		return nil, err
+	}
+
+	fs.Parse(args)
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// readFile applies the settings in a configuration file. Each line is a
+// "name = value" pair, blank lines and lines starting with # are ignored.
+func readFile(fs *flag.FlagSet, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, "=", 2)
+
+		if len(fields) != 2 {
+			return fmt.Errorf("line %v: expected name = value", lineNumber)
+		}
+
+		name := strings.TrimSpace(fields[0])
+		value := strings.TrimSpace(fields[1])
+
+		if err := set(fs, name, value); err != nil {
+			return fmt.Errorf("line %v: %v", lineNumber, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// readEnv applies the settings given through environment variables
+func readEnv(fs *flag.FlagSet, getenv func(string) string) error {
+	var err error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		value := getenv(envName(f.Name))
+
+		if value != "" && err == nil && !internal(f.Name) {
+			if setErr := set(fs, f.Name, value); setErr != nil {
+				err = fmt.Errorf("%s: %v", envName(f.Name), setErr)
+			}
+		}
+	})
+
+	return err
+}
+
+// internal returns true for the flags that aren't settings of their own
+func internal(name string) bool {
+	return name == "config" || name == "print-config"
+}
+
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+func set(fs *flag.FlagSet, name string, value string) error {
+	if fs.Lookup(name) == nil || internal(name) {
+		return errors.New("unknown setting " + name)
+	}
+
+	if err := fs.Set(name, value); err != nil {
+		return fmt.Errorf("invalid value %q for %s", value, name)
+	}
+
+	return nil
+}
+
+func (self *Config) validate() error {
+	if self.MinNameLength < 1 || self.MaxNameLength < self.MinNameLength {
+		return errors.New("Name length limits must satisfy 1 <= min-name-length <= max-name-length")
+	}
+
+	if self.InputInterval < 0 || self.ShutdownCountdown < 0 || self.CombatInterval <= 0 || self.RoamInterval <= 0 {
+		return errors.New("Intervals must be positive")
+	}
+
+	if self.MaxCharacters < 0 {
+		return errors.New("max-characters can't be negative")
+	}
+
+	if self.DeleteRetention < 0 {
+		return errors.New("delete-retention can't be negative")
+	}
+
+	if self.MaxConnections < 0 || self.MaxConnectionsPerAddress < 0 {
+		return errors.New("Connection limits can't be negative")
+	}
+
+	if self.MenuIdleTimeout < 0 || self.IdleTimeout < 0 || self.IdleWarning < 0 || self.KeepAlive < 0 ||
+		self.LinkDeadTimeout < 0 {
+		return errors.New("Timeouts can't be negative")
+	}
+
+	if self.LoginAttempts < 1 || self.AddressLoginAttempts < 1 || self.NewUsersPerAddress < 1 || self.LoginLockout <= 0 {
+		return errors.New("Login limits must be positive")
+	}
+
+	if self.MaxProcs < 1 {
+		return errors.New("max-procs must be at least 1")
+	}
+
+	return nil
+}
+
+// Write writes every setting in configuration file format
+func (self *Config) Write(w io.Writer) {
+	// The flags point into config, so copying the settings over it makes them
+	// the flags' values
+	var config Config
+	fs := flag.NewFlagSet("kmud", flag.ContinueOnError)
+	config.register(fs)
+	config = *self
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if !internal(f.Name) {
+			fmt.Fprintf(w, "# %s\n%s = %s\n\n", f.Usage, f.Name, f.Value.String())
+		}
+	})
+}
+
+// vim: nocindent
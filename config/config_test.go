@@ -0,0 +1,138 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Default(t *testing.T) {
+	config := Default()
+
+	if config.Addr != ":8945" || config.DatabaseName != "mud" || config.InputInterval != 200*time.Millisecond ||
+		config.MinPasswordLength != 7 || config.MaxNameLength != 12 {
+		t.Errorf("Default() == %+v", config)
+	}
+}
+
+func Test_ReadFile(t *testing.T) {
+	var config Config
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	config.register(fs)
+
+	file := `
+# Staging
+db-name = mud_staging
+combat-interval=5s
+  unicode-names = true
+`
+
+	if err := readFile(fs, strings.NewReader(file)); err != nil {
+		t.Fatalf("readFile() failed: %v", err)
+	}
+
+	if config.DatabaseName != "mud_staging" || config.CombatInterval != 5*time.Second || !config.UnicodeNames {
+		t.Errorf("readFile() gave %+v", config)
+	}
+
+	tests := []string{
+		"db-name",
+		"no-such-setting = 1",
+		"regen-amount = lots",
+		"print-config = true",
+	}
+
+	for _, test := range tests {
+		if err := readFile(fs, strings.NewReader(test)); err == nil {
+			t.Errorf("readFile(%q) should have failed", test)
+		} else if !strings.HasPrefix(err.Error(), "line 1: ") {
+			t.Errorf("readFile(%q) error %q doesn't give the line number", test, err)
+		}
+	}
+}
+
+func Test_ReadEnv(t *testing.T) {
+	var config Config
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	config.register(fs)
+
+	env := map[string]string{
+		"KMUD_DB_HOST":         "db.example.com",
+		"KMUD_MAX_NAME_LENGTH": "20",
+	}
+
+	if err := readEnv(fs, func(name string) string { return env[name] }); err != nil {
+		t.Fatalf("readEnv() failed: %v", err)
+	}
+
+	if config.DatabaseHost != "db.example.com" || config.MaxNameLength != 20 {
+		t.Errorf("readEnv() gave %+v", config)
+	}
+
+	env["KMUD_REGEN_AMOUNT"] = "x"
+	if err := readEnv(fs, func(name string) string { return env[name] }); err == nil {
+		t.Errorf("readEnv() accepted an invalid value")
+	}
+}
+
+func Test_Load(t *testing.T) {
+	file, err := ioutil.TempFile("", "kmud-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("addr = :9000\ndb-name = from_file\nregen-amount = 3\n")
+	file.Close()
+
+	os.Setenv("KMUD_DB_NAME", "from_env")
+	os.Setenv("KMUD_MIN_PASSWORD_LENGTH", "10")
+	defer os.Unsetenv("KMUD_DB_NAME")
+	defer os.Unsetenv("KMUD_MIN_PASSWORD_LENGTH")
+
+	config, err := Load([]string{"-config", file.Name(), "-min-password-length", "12"})
+
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	// The file overrides the defaults, the environment overrides the file and
+	// the command line overrides everything
+	if config.Addr != ":9000" || config.RegenAmount != 3 || config.DatabaseName != "from_env" ||
+		config.MinPasswordLength != 12 {
+		t.Errorf("Load() gave %+v", config)
+	}
+
+	if _, err := Load([]string{"-min-name-length", "5", "-max-name-length", "4"}); err == nil {
+		t.Errorf("Load() accepted inconsistent name length limits")
+	}
+}
+
+func Test_Write(t *testing.T) {
+	config := Default()
+	config.DatabaseName = "mud_dev"
+	config.RoamInterval = 2 * time.Second
+
+	var buf bytes.Buffer
+	config.Write(&buf)
+
+	if strings.Contains(buf.String(), "print-config") {
+		t.Errorf("Write() included print-config")
+	}
+
+	var read Config
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	read.register(fs)
+
+	if err := readFile(fs, &buf); err != nil {
+		t.Fatalf("Reading back the output of Write() failed: %v", err)
+	}
+
+	if read != *config {
+		t.Errorf("Write() then readFile() == %+v, want %+v", read, *config)
+	}
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"github.com/Cristofori/kmud/datastore"
+	"net"
+)
+
+// Ban keeps everyone connecting from an address range out, whichever user
+// they log in as
+type Ban struct {
+	DbObject `bson:",inline"`
+
+	Range    string // In CIDR notation
+	Sanction Sanction
+}
+
+func NewBan(network *net.IPNet, sanction Sanction) *Ban {
+	var ban Ban
+
+	ban.Range = network.String()
+	ban.Sanction = sanction
+
+	ban.initDbObject(&ban)
+	return &ban
+}
+
+func (self *Ban) GetType() datastore.ObjectType {
+	return BanType
+}
+
+func (self *Ban) GetRange() string {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	return self.Range
+}
+
+func (self *Ban) GetSanction() Sanction {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	return self.Sanction
+}
+
+// Contains returns true if the address is in the banned range
+func (self *Ban) Contains(ip net.IP) bool {
+	_, network, err := net.ParseCIDR(self.GetRange())
+	return err == nil && network.Contains(ip)
+}
+
+// vim: nocindent
@@ -0,0 +1,29 @@
+package database
+
+import (
+	"net"
+	"testing"
+)
+
+func Test_BanContains(t *testing.T) {
+	var tests = []struct {
+		banned   string
+		address  string
+		contains bool
+	}{
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "11.0.0.1", false},
+		{"192.168.1.7/32", "192.168.1.7", true},
+		{"192.168.1.7/32", "192.168.1.8", false},
+		{"2001:db8::/32", "2001:db8::1", true},
+		{"2001:db8::/32", "2001:db9::1", false},
+		{"10.0.0.0/8", "2001:db8::1", false},
+	}
+
+	for _, test := range tests {
+		ban := Ban{Range: test.banned}
+		if contains := ban.Contains(net.ParseIP(test.address)); contains != test.contains {
+			t.Errorf("Ban{%s}.Contains(%s) == %v, want %v", test.banned, test.address, contains, test.contains)
+		}
+	}
+}
@@ -17,9 +17,36 @@ type Character struct {
 	Health    int
 	HitPoints int
 
+	Attributes Attributes
+
 	objType datastore.ObjectType
 }
 
+// Attributes are picked by players when they create a character
+type Attributes struct {
+	Strength     int
+	Dexterity    int
+	Constitution int
+	Intelligence int
+}
+
+// Value that every attribute starts out with
+const BaseAttribute = 10
+
+func DefaultAttributes() Attributes {
+	return Attributes{
+		Strength:     BaseAttribute,
+		Dexterity:    BaseAttribute,
+		Constitution: BaseAttribute,
+		Intelligence: BaseAttribute,
+	}
+}
+
+func (self Attributes) String() string {
+	return fmt.Sprintf("Strength %v, Dexterity %v, Constitution %v, Intelligence %v",
+		self.Strength, self.Dexterity, self.Constitution, self.Intelligence)
+}
+
 type NonPlayerChar struct {
 	Character `bson:",inline"`
 
@@ -31,7 +58,13 @@ type PlayerChar struct {
 	Character `bson:",inline"`
 
 	UserId bson.ObjectId
-	online bool
+
+	// Where the character was taken to jail from, and goes back to once it's
+	// released
+	JailedFromId bson.ObjectId `bson:",omitempty"`
+
+	online   bool
+	linkDead bool
 }
 
 type CharacterList []*Character
@@ -113,6 +146,40 @@ func (self *PlayerChar) IsOnline() bool {
 	return self.online
 }
 
+// SetLinkDead marks an online character whose connection has dropped, and
+// that's waiting in the world for its user to log back in
+func (self *PlayerChar) SetLinkDead(linkDead bool) {
+	self.WriteLock()
+	self.linkDead = linkDead
+	self.WriteUnlock()
+}
+
+func (self *PlayerChar) IsLinkDead() bool {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	return self.linkDead
+}
+
+func (self *PlayerChar) SetJailedFromId(id bson.ObjectId) {
+	self.WriteLock()
+	defer self.WriteUnlock()
+
+	if id != self.JailedFromId {
+		self.JailedFromId = id
+		objectModified(self)
+	}
+}
+
+// GetJailedFromId returns the room the character was in before it was taken
+// to jail, empty if it isn't in jail
+func (self *PlayerChar) GetJailedFromId() bson.ObjectId {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	return self.JailedFromId
+}
+
 /*
 func (self *Character) IsNpcTemplate() bool {
 	self.ReadLock()
@@ -266,6 +333,23 @@ func (self *Character) SetHealth(health int) {
 	}
 }
 
+func (self *Character) SetAttributes(attributes Attributes) {
+	self.WriteLock()
+	defer self.WriteUnlock()
+
+	if attributes != self.Attributes {
+		self.Attributes = attributes
+		objectModified(self)
+	}
+}
+
+func (self *Character) GetAttributes() Attributes {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	return self.Attributes
+}
+
 func (self *Character) GetHealth() int {
 	self.ReadLock()
 	defer self.ReadUnlock()
@@ -39,6 +39,7 @@ type Iterator interface {
 
 var modifiedObjects map[bson.ObjectId]bool
 var modifiedObjectChannel chan bson.ObjectId
+var flushChannel chan chan bool
 
 var _session Session
 var _dbName string
@@ -49,6 +50,7 @@ func Init(session Session, dbName string) {
 
 	modifiedObjects = make(map[bson.ObjectId]bool)
 	modifiedObjectChannel = make(chan bson.ObjectId, 10)
+	flushChannel = make(chan chan bool)
 
 	go watchModifiedObjects()
 }
@@ -59,24 +61,58 @@ func objectModified(obj datastore.Identifiable) {
 
 func watchModifiedObjects() {
 	for {
-		id := <-modifiedObjectChannel
-		modifiedObjectsMutex.Lock()
-		modifiedObjects[id] = true
-		modifiedObjectsMutex.Unlock()
-
-		// TODO FIXME - Periodically save in separate routine
-		saveModifiedObjects()
+		select {
+		case id := <-modifiedObjectChannel:
+			markModified(id)
+
+			// TODO FIXME - Periodically save in separate routine
+			saveModifiedObjects()
+
+		case done := <-flushChannel:
+			// Pick up everything that's still queued before saving
+		drain:
+			for {
+				select {
+				case id := <-modifiedObjectChannel:
+					markModified(id)
+				default:
+					break drain
+				}
+			}
+
+			saveModifiedObjects()
+			done <- true
+		}
 	}
 }
 
+func markModified(id bson.ObjectId) {
+	modifiedObjectsMutex.Lock()
+	modifiedObjects[id] = true
+	modifiedObjectsMutex.Unlock()
+}
+
 func saveModifiedObjects() {
 	modifiedObjectsMutex.Lock()
 	for id := range modifiedObjects {
 		commitObject(id)
+		delete(modifiedObjects, id)
 	}
 	modifiedObjectsMutex.Unlock()
 }
 
+// Flush commits every modified object to the database, including the ones
+// still waiting in the queue, and returns once they've all been saved
+func Flush() {
+	if flushChannel == nil {
+		return
+	}
+
+	done := make(chan bool)
+	flushChannel <- done
+	<-done
+}
+
 func getCollection(collection collectionName) Collection {
 	return _session.DB(_dbName).C(string(collection))
 }
@@ -101,6 +137,8 @@ func getCollectionFromType(t datastore.ObjectType) Collection {
 		return getCollection(cRooms)
 	case ItemType:
 		return getCollection(cItems)
+	case BanType:
+		return getCollection(cBans)
 	default:
 		panic("database.getCollectionFromType: Unhandled object type")
 	}
@@ -117,6 +155,7 @@ const (
 	cZones          = collectionName("zones")
 	cItems          = collectionName("items")
 	cAreas          = collectionName("areas")
+	cBans           = collectionName("bans")
 )
 
 // Field names
@@ -127,6 +166,7 @@ const (
 	fRoom         = "room"
 	fLocation     = "location"
 	fDefault      = "default"
+	fDeleted      = "deleted"
 )
 
 // MongDB operations
@@ -141,12 +181,21 @@ func RetrieveObjects(t datastore.ObjectType, objects interface{}) error {
 	return c.Find(nil).Iter().All(objects)
 }
 
+// Find returns the objects of the given type with the given value for key,
+// leaving out deleted ones
 func Find(t datastore.ObjectType, key string, value interface{}) []bson.ObjectId {
-	return find(t, bson.M{key: value})
+	return find(t, bson.M{key: value, fDeleted: bson.M{"$exists": false}})
 }
 
+// FindAll returns all the objects of the given type that aren't deleted
 func FindAll(t datastore.ObjectType) []bson.ObjectId {
-	return find(t, nil)
+	return find(t, bson.M{fDeleted: bson.M{"$exists": false}})
+}
+
+// FindDeleted returns the objects of the given type that are deleted but
+// haven't been purged yet
+func FindDeleted(t datastore.ObjectType) []bson.ObjectId {
+	return find(t, bson.M{fDeleted: bson.M{"$exists": true}})
 }
 
 func find(t datastore.ObjectType, query interface{}) []bson.ObjectId {
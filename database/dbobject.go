@@ -4,11 +4,16 @@ import (
 	"gopkg.in/mgo.v2/bson"
 	"github.com/Cristofori/kmud/datastore"
 	"sync"
+	"time"
 )
 
 type DbObject struct {
 	Id bson.ObjectId `bson:"_id"`
 
+	// Set when the object is deleted, it's kept around as a tombstone until
+	// it's purged so that it can still be restored
+	Deleted time.Time `bson:",omitempty"`
+
 	mutex     sync.RWMutex
 	destroyed bool
 }
@@ -54,4 +59,40 @@ func (self *DbObject) IsDestroyed() bool {
 	return self.destroyed
 }
 
+// MarkDeleted turns the object into a tombstone, which hides it from queries
+// until it's either restored or purged. It's committed right away, rather than
+// queued, so that queries stop finding it as soon as this returns.
+func (self *DbObject) MarkDeleted(when time.Time) {
+	self.WriteLock()
+	self.Deleted = when
+	self.WriteUnlock()
+
+	commitObject(self.Id)
+}
+
+// Restore brings a tombstoned object back, committing it right away like
+// MarkDeleted
+func (self *DbObject) Restore() {
+	self.WriteLock()
+	self.Deleted = time.Time{}
+	self.WriteUnlock()
+
+	commitObject(self.Id)
+}
+
+func (self *DbObject) IsDeleted() bool {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	return !self.Deleted.IsZero()
+}
+
+// DeletedAt returns when the object was deleted, the zero time if it wasn't
+func (self *DbObject) DeletedAt() time.Time {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	return self.Deleted
+}
+
 // vim: nocindent
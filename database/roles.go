@@ -0,0 +1,60 @@
+package database
+
+import (
+	"strings"
+)
+
+// Role is a set of permissions that can be granted to a user. Every user is a
+// player, the other roles add to what players can do.
+type Role string
+
+const (
+	RolePlayer  Role = "player"
+	RoleBuilder Role = "builder"
+	RoleAdmin   Role = "admin"
+)
+
+// Roles lists the roles that can be granted, players are everyone
+var Roles = []Role{RoleBuilder, RoleAdmin}
+
+// Permission allows a user to do something that players can't
+type Permission string
+
+const (
+	PermissionBuild    Permission = "build"    // Create and edit rooms, zones, areas, NPCs and items
+	PermissionTeleport Permission = "teleport" // Move anywhere in the world
+	PermissionAdmin    Permission = "admin"    // Use the admin menu, to manage users
+	PermissionRoles    Permission = "roles"    // Grant and revoke roles
+	PermissionModerate Permission = "moderate" // Ban, mute and jail users
+)
+
+var rolePermissions = map[Role][]Permission{
+	RolePlayer:  {},
+	RoleBuilder: {PermissionBuild, PermissionTeleport},
+	RoleAdmin:   {PermissionBuild, PermissionTeleport, PermissionAdmin, PermissionRoles, PermissionModerate},
+}
+
+// ParseRole returns the role with the given name, false if there's no such
+// role that can be granted
+func ParseRole(name string) (Role, bool) {
+	for _, role := range Roles {
+		if strings.ToLower(name) == string(role) {
+			return role, true
+		}
+	}
+
+	return "", false
+}
+
+// Has returns true if the role includes the given permission
+func (self Role) Has(permission Permission) bool {
+	for _, p := range rolePermissions[self] {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// vim: nocindent
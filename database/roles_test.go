@@ -0,0 +1,58 @@
+package database
+
+import (
+	"testing"
+)
+
+func Test_ParseRole(t *testing.T) {
+	var tests = []struct {
+		input string
+		role  Role
+		found bool
+	}{
+		{"builder", RoleBuilder, true},
+		{"Admin", RoleAdmin, true},
+		{"ADMIN", RoleAdmin, true},
+		{"player", "", false}, // Everyone is a player, it can't be granted
+		{"god", "", false},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		role, found := ParseRole(test.input)
+		if role != test.role || found != test.found {
+			t.Errorf("ParseRole(%q) == %q, %v, want %q, %v", test.input, role, found, test.role, test.found)
+		}
+	}
+}
+
+func Test_RoleHas(t *testing.T) {
+	var tests = []struct {
+		role       Role
+		permission Permission
+		has        bool
+	}{
+		{RolePlayer, PermissionBuild, false},
+		{RolePlayer, PermissionTeleport, false},
+		{RolePlayer, PermissionAdmin, false},
+		{RolePlayer, PermissionRoles, false},
+		{RolePlayer, PermissionModerate, false},
+		{RoleBuilder, PermissionBuild, true},
+		{RoleBuilder, PermissionTeleport, true},
+		{RoleBuilder, PermissionAdmin, false},
+		{RoleBuilder, PermissionRoles, false},
+		{RoleBuilder, PermissionModerate, false},
+		{RoleAdmin, PermissionBuild, true},
+		{RoleAdmin, PermissionTeleport, true},
+		{RoleAdmin, PermissionAdmin, true},
+		{RoleAdmin, PermissionRoles, true},
+		{RoleAdmin, PermissionModerate, true},
+		{Role("god"), PermissionAdmin, false},
+	}
+
+	for _, test := range tests {
+		if has := test.role.Has(test.permission); has != test.has {
+			t.Errorf("%q.Has(%q) == %v, want %v", test.role, test.permission, has, test.has)
+		}
+	}
+}
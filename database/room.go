@@ -30,6 +30,9 @@ type Room struct {
 	ExitDown      bool
 
 	Properties map[string]string
+
+	// Jailed characters are kept in jail rooms, and can't leave them
+	Jail bool
 }
 
 func NewRoom(zoneId bson.ObjectId, location Coordinate) *Room {
@@ -72,11 +75,11 @@ func (self *Room) ToString(players []*PlayerChar, npcs []*NonPlayerChar, items [
 
 	str = fmt.Sprintf("\r\n %v>>> %v%s%s %v<<< %v(%v %v %v)\r\n\r\n %v%s\r\n\r\n",
 		utils.ColorWhite, utils.ColorBlue,
-		self.GetTitle(), areaStr,
+		utils.EscapeLinks(self.GetTitle()), utils.EscapeLinks(areaStr),
 		utils.ColorWhite, utils.ColorBlue,
 		self.GetLocation().X, self.GetLocation().Y, self.GetLocation().Z,
 		utils.ColorWhite,
-		self.GetDescription())
+		utils.EscapeLinks(self.GetDescription()))
 
 	extraNewLine := ""
 
@@ -85,7 +88,13 @@ func (self *Room) ToString(players []*PlayerChar, npcs []*NonPlayerChar, items [
 
 		var names []string
 		for _, char := range players {
-			names = append(names, utils.Colorize(utils.ColorWhite, char.GetName()))
+			name := utils.Link("look "+char.GetName(), utils.Colorize(utils.ColorWhite, char.GetName()))
+
+			if char.IsLinkDead() {
+				name = name + utils.Colorize(utils.ColorDarkBlue, " (linkdead)")
+			}
+
+			names = append(names, name)
 		}
 		str = str + strings.Join(names, utils.Colorize(utils.ColorBlue, ", ")) + "\n"
 
@@ -97,7 +106,7 @@ func (self *Room) ToString(players []*PlayerChar, npcs []*NonPlayerChar, items [
 
 		var names []string
 		for _, npc := range npcs {
-			names = append(names, utils.Colorize(utils.ColorWhite, npc.GetName()))
+			names = append(names, utils.Link("talk "+npc.GetName(), utils.Colorize(utils.ColorWhite, npc.GetName())))
 		}
 		str = str + strings.Join(names, utils.Colorize(utils.ColorBlue, ", ")) + "\r\n"
 
@@ -126,10 +135,11 @@ func (self *Room) ToString(players []*PlayerChar, npcs []*NonPlayerChar, items [
 
 		var names []string
 		for _, name := range nameList {
+			text := name
 			if itemMap[name] > 1 {
-				name = fmt.Sprintf("%s x%v", name, itemMap[name])
+				text = fmt.Sprintf("%s x%v", name, itemMap[name])
 			}
-			names = append(names, utils.Colorize(utils.ColorWhite, name))
+			names = append(names, utils.Link("get "+name, utils.Colorize(utils.ColorWhite, text)))
 		}
 		str = str + strings.Join(names, utils.Colorize(utils.ColorBlue, ", ")) + "\r\n"
 
@@ -278,6 +288,23 @@ func (self *Room) GetTitle() string {
 	return self.Title
 }
 
+func (self *Room) SetJail(jail bool) {
+	self.WriteLock()
+	defer self.WriteUnlock()
+
+	if jail != self.Jail {
+		self.Jail = jail
+		objectModified(self)
+	}
+}
+
+func (self *Room) IsJail() bool {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	return self.Jail
+}
+
 func (self *Room) SetDescription(description string) {
 	self.WriteLock()
 	defer self.WriteUnlock()
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SanctionKind is a moderation measure that can be taken against a user
+type SanctionKind string
+
+const (
+	SanctionBan  SanctionKind = "ban"  // Can't log in
+	SanctionMute SanctionKind = "mute" // Can't say, tell, broadcast or emote
+	SanctionJail SanctionKind = "jail" // Kept in a jail room
+)
+
+// Sanction is a moderation measure in effect against a user, or against an
+// address range
+type Sanction struct {
+	Since  time.Time
+	Until  time.Time `bson:",omitempty"` // Zero for a sanction that doesn't run out
+	Reason string
+	By     string
+}
+
+// NewSanction returns a sanction starting now and lasting for the given
+// duration, or forever if it's zero
+func NewSanction(duration time.Duration, reason string, by string) Sanction {
+	now := time.Now()
+
+	sanction := Sanction{Since: now, Reason: reason, By: by}
+
+	if duration > 0 {
+		sanction.Until = now.Add(duration)
+	}
+
+	return sanction
+}
+
+// Active returns true if the sanction hasn't run out at the given time
+func (self Sanction) Active(now time.Time) bool {
+	return self.Until.IsZero() || now.Before(self.Until)
+}
+
+// String describes how long the sanction lasts and why, for showing to users
+func (self Sanction) String() string {
+	var parts []string
+
+	if self.Until.IsZero() {
+		parts = append(parts, "indefinitely")
+	} else {
+		parts = append(parts, "until "+self.Until.Format("2006-01-02 15:04"))
+	}
+
+	if self.By != "" {
+		parts = append(parts, "by "+self.By)
+	}
+
+	str := strings.Join(parts, " ")
+
+	if self.Reason != "" {
+		str = fmt.Sprintf("%s (%s)", str, self.Reason)
+	}
+
+	return str
+}
+
+// vim: nocindent
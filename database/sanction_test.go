@@ -0,0 +1,41 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_SanctionActive(t *testing.T) {
+	now := time.Now()
+
+	var tests = []struct {
+		until  time.Time
+		active bool
+	}{
+		{time.Time{}, true}, // Indefinite
+		{now.Add(time.Hour), true},
+		{now.Add(-time.Hour), false},
+		{now, false},
+	}
+
+	for _, test := range tests {
+		sanction := Sanction{Since: now.Add(-2 * time.Hour), Until: test.until}
+		if active := sanction.Active(now); active != test.active {
+			t.Errorf("Sanction{Until: %v}.Active() == %v, want %v", test.until, active, test.active)
+		}
+	}
+}
+
+func Test_NewSanction(t *testing.T) {
+	indefinite := NewSanction(0, "reason", "admin")
+
+	if !indefinite.Until.IsZero() || !indefinite.Active(time.Now().Add(24*365*time.Hour)) {
+		t.Errorf("NewSanction(0) should never run out, until == %v", indefinite.Until)
+	}
+
+	timed := NewSanction(time.Hour, "reason", "admin")
+
+	if !timed.Active(time.Now()) || timed.Active(time.Now().Add(2*time.Hour)) {
+		t.Errorf("NewSanction(time.Hour) should run out after an hour, until == %v", timed.Until)
+	}
+}
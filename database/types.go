@@ -31,6 +31,7 @@ const (
 	AreaType datastore.ObjectType = iota
 	RoomType datastore.ObjectType = iota
 	ItemType datastore.ObjectType = iota
+	BanType  datastore.ObjectType = iota
 )
 
 type Coordinate struct {
@@ -45,11 +46,11 @@ func directionToExitString(direction Direction) string {
 	textColor := utils.ColorWhite
 
 	colorize := func(letters string, text string) string {
-		return fmt.Sprintf("%s%s%s%s",
+		return utils.Link(DirectionToAbbreviation(direction), fmt.Sprintf("%s%s%s%s",
 			utils.Colorize(bracketColor, "["),
 			utils.Colorize(letterColor, letters),
 			utils.Colorize(bracketColor, "]"),
-			utils.Colorize(textColor, text))
+			utils.Colorize(textColor, text)))
 	}
 
 	switch direction {
@@ -180,6 +181,37 @@ func DirectionToString(dir Direction) string {
 	panic("Unexpected code path")
 }
 
+// DirectionToAbbreviation returns the short form of the direction that players
+// type in order to move (e.g. "ne" for North East)
+func DirectionToAbbreviation(dir Direction) string {
+	switch dir {
+	case DirectionNorth:
+		return "n"
+	case DirectionNorthEast:
+		return "ne"
+	case DirectionEast:
+		return "e"
+	case DirectionSouthEast:
+		return "se"
+	case DirectionSouth:
+		return "s"
+	case DirectionSouthWest:
+		return "sw"
+	case DirectionWest:
+		return "w"
+	case DirectionNorthWest:
+		return "nw"
+	case DirectionUp:
+		return "u"
+	case DirectionDown:
+		return "d"
+	case DirectionNone:
+		return ""
+	}
+
+	panic("Unexpected code path")
+}
+
 func (self Direction) Opposite() Direction {
 	switch self {
 	case DirectionNorth:
@@ -1,33 +1,79 @@
 package database
 
 import (
-	"crypto/sha1"
-	"io"
 	"github.com/Cristofori/kmud/datastore"
 	"github.com/Cristofori/kmud/utils"
 	"net"
-	"reflect"
+	"time"
 )
 
 type User struct {
 	DbObject `bson:",inline"`
 
-	Name      string
-	ColorMode utils.ColorMode
-	Password  []byte
+	Name         string
+	ColorMode    utils.ColorMode
+	ColorModeSet bool
+	CharMode     bool
+	Password     []byte
+	PublicKeys   []string
+
+	// The password was given out by an admin, and has to be changed at the
+	// next login
+	PasswordTemporary bool
+	Roles             []Role
+	Sanctions         map[SanctionKind]Sanction `bson:",omitempty"`
 
 	online       bool
 	conn         net.Conn
 	windowWidth  int
 	windowHeight int
 	terminalType string
+	capabilities Capabilities
+}
+
+// Capabilities describes what the user's client is able to handle, as
+// detected through terminal type negotiation (MTTS)
+type Capabilities struct {
+	ClientName      string
+	ANSI            bool
+	VT100           bool
+	UTF8            bool
+	Colors256       bool
+	TrueColor       bool
+	MouseTracking   bool
+	OSCColorPalette bool
+	ScreenReader    bool
+	Proxy           bool
+	MNES            bool
+	MSLP            bool
+	SSL             bool
+}
+
+// DefaultColorMode returns the color mode that best suits a client with these
+// capabilities
+func (self Capabilities) DefaultColorMode() utils.ColorMode {
+	if self.ANSI && !self.ScreenReader {
+		return utils.ColorModeLight
+	}
+
+	return utils.ColorModeNone
+}
+
+// CursorControl returns true if the client understands VT100 cursor control
+// sequences. Clients that didn't report a terminal type are assumed to.
+func (self Capabilities) CursorControl() bool {
+	if self.ClientName == "" {
+		return true
+	}
+
+	return (self.ANSI || self.VT100) && !self.ScreenReader
 }
 
 func NewUser(name string, password string) *User {
 	var user User
 
 	user.Name = utils.FormatName(name)
-	user.Password = hash(password)
+	user.Password = utils.HashPassword(password)
 	user.ColorMode = utils.ColorModeNone
 	user.online = false
 
@@ -61,12 +107,16 @@ func (self *User) Online() bool {
 	return self.online
 }
 
+// SetColorMode records the user's explicit choice of color mode, which takes
+// precedence over the default picked from the client's capabilities
 func (self *User) SetColorMode(cm utils.ColorMode) {
-	if cm != self.GetColorMode() {
-		self.WriteLock()
-		self.ColorMode = cm
-		self.WriteUnlock()
+	self.WriteLock()
+	changed := cm != self.ColorMode || !self.ColorModeSet
+	self.ColorMode = cm
+	self.ColorModeSet = true
+	self.WriteUnlock()
 
+	if changed {
 		objectModified(self)
 	}
 }
@@ -75,34 +125,60 @@ func (self *User) GetColorMode() utils.ColorMode {
 	self.ReadLock()
 	defer self.ReadUnlock()
 
-	return self.ColorMode
-}
+	// Users created before ColorModeSet existed only had a non-default color
+	// mode if they picked one themselves
+	if self.ColorModeSet || self.ColorMode != utils.ColorModeNone {
+		return self.ColorMode
+	}
 
-func hash(data string) []byte {
-	h := sha1.New()
-	io.WriteString(h, data)
-	return h.Sum(nil)
+	return self.capabilities.DefaultColorMode()
 }
 
-// SetPassword SHA1 hashes the password before saving it to the database
+// SetPassword hashes the password (see utils.HashPassword) before saving it
+// to the database
 func (self *User) SetPassword(password string) {
-	hashed := hash(password)
+	self.setPassword(password, false)
+}
 
-	if !reflect.DeepEqual(hashed, self.GetPassword()) {
-		self.WriteLock()
-		self.Password = hashed
-		self.WriteUnlock()
+// SetTemporaryPassword sets a password that the user has to change the next
+// time they log in
+func (self *User) SetTemporaryPassword(password string) {
+	self.setPassword(password, true)
+}
 
-		objectModified(self)
-	}
+func (self *User) setPassword(password string, temporary bool) {
+	hashed := utils.HashPassword(password)
+
+	self.WriteLock()
+	self.Password = hashed
+	self.PasswordTemporary = temporary
+	self.WriteUnlock()
+
+	objectModified(self)
+}
+
+// MustChangePassword returns true if the user's password is a temporary one
+func (self *User) MustChangePassword() bool {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	return self.PasswordTemporary
 }
 
+// VerifyPassword returns true if the password is the user's. A password
+// stored with an outdated hash (e.g. the original unsalted SHA-1) is
+// rehashed with the current scheme once it's been verified.
 func (self *User) VerifyPassword(password string) bool {
-	hashed := hash(password)
-	return reflect.DeepEqual(hashed, self.GetPassword())
+	match, outdated := utils.CheckPassword(self.GetPassword(), password)
+
+	if outdated {
+		self.setPassword(password, self.MustChangePassword())
+	}
+
+	return match
 }
 
-// GetPassword returns the SHA1 of the user's password
+// GetPassword returns the hash of the user's password
 func (self *User) GetPassword() []byte {
 	self.ReadLock()
 	defer self.ReadUnlock()
@@ -127,6 +203,180 @@ func (self *User) WindowSize() (width int, height int) {
 	return self.windowWidth, self.windowHeight
 }
 
+// SetCharMode records whether the user wants their input to be read a
+// character at a time, with line editing and tab completion
+func (self *User) SetCharMode(enabled bool) {
+	self.WriteLock()
+	changed := enabled != self.CharMode
+	self.CharMode = enabled
+	self.WriteUnlock()
+
+	if changed {
+		objectModified(self)
+	}
+}
+
+func (self *User) GetCharMode() bool {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	return self.CharMode
+}
+
+// AddPublicKey registers an SSH public key (in authorized_keys format) that
+// the user can log in with
+func (self *User) AddPublicKey(key string) {
+	if self.HasPublicKey(key) {
+		return
+	}
+
+	self.WriteLock()
+	self.PublicKeys = append(self.PublicKeys, key)
+	self.WriteUnlock()
+
+	objectModified(self)
+}
+
+func (self *User) RemovePublicKey(key string) {
+	self.WriteLock()
+	defer self.WriteUnlock()
+
+	for i, k := range self.PublicKeys {
+		if k == key {
+			self.PublicKeys = append(self.PublicKeys[:i], self.PublicKeys[i+1:]...)
+			objectModified(self)
+			return
+		}
+	}
+}
+
+func (self *User) HasPublicKey(key string) bool {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	for _, k := range self.PublicKeys {
+		if k == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (self *User) GetPublicKeys() []string {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	keys := make([]string, len(self.PublicKeys))
+	copy(keys, self.PublicKeys)
+	return keys
+}
+
+// GrantRole gives the user a role, and all of its permissions
+func (self *User) GrantRole(role Role) {
+	if role == RolePlayer || self.HasRole(role) {
+		return
+	}
+
+	self.WriteLock()
+	self.Roles = append(self.Roles, role)
+	self.WriteUnlock()
+
+	objectModified(self)
+}
+
+func (self *User) RevokeRole(role Role) {
+	self.WriteLock()
+	defer self.WriteUnlock()
+
+	for i, r := range self.Roles {
+		if r == role {
+			self.Roles = append(self.Roles[:i], self.Roles[i+1:]...)
+			objectModified(self)
+			return
+		}
+	}
+}
+
+// HasRole returns true if the user has been granted the role, everyone is a
+// player
+func (self *User) HasRole(role Role) bool {
+	if role == RolePlayer {
+		return true
+	}
+
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	for _, r := range self.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetRoles returns the user's roles, starting with player
+func (self *User) GetRoles() []Role {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	return append([]Role{RolePlayer}, self.Roles...)
+}
+
+// HasPermission returns true if any of the user's roles includes the
+// permission
+func (self *User) HasPermission(permission Permission) bool {
+	for _, role := range self.GetRoles() {
+		if role.Has(permission) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Impose puts a sanction on the user, replacing any earlier one of the same
+// kind
+func (self *User) Impose(kind SanctionKind, sanction Sanction) {
+	self.WriteLock()
+	if self.Sanctions == nil {
+		self.Sanctions = map[SanctionKind]Sanction{}
+	}
+	self.Sanctions[kind] = sanction
+	self.WriteUnlock()
+
+	objectModified(self)
+}
+
+// Lift removes the sanction of the given kind, returns false if the user
+// wasn't under one that was still in effect
+func (self *User) Lift(kind SanctionKind) bool {
+	_, active := self.GetSanction(kind)
+
+	self.WriteLock()
+	_, found := self.Sanctions[kind]
+	delete(self.Sanctions, kind)
+	self.WriteUnlock()
+
+	if found {
+		objectModified(self)
+	}
+
+	return active
+}
+
+// GetSanction returns the sanction of the given kind that the user is under,
+// false if there isn't one or if it has run out
+func (self *User) GetSanction(kind SanctionKind) (Sanction, bool) {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	sanction, found := self.Sanctions[kind]
+	return sanction, found && sanction.Active(time.Now())
+}
+
 func (self *User) SetTerminalType(tt string) {
 	self.terminalType = tt
 }
@@ -135,6 +385,20 @@ func (self *User) TerminalType() string {
 	return self.terminalType
 }
 
+func (self *User) SetCapabilities(caps Capabilities) {
+	self.WriteLock()
+	defer self.WriteUnlock()
+
+	self.capabilities = caps
+}
+
+func (self *User) GetCapabilities() Capabilities {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	return self.capabilities
+}
+
 func (self *User) GetInput(text string) string {
 	return utils.GetUserInput(self.conn, text, self.GetColorMode())
 }
@@ -147,6 +411,12 @@ func (self *User) Write(text string) (int, error) {
 	return utils.Write(self.conn, text, self.GetColorMode())
 }
 
+// WritePrompt writes the given prompt, marking where it ends for clients that
+// support it
+func (self *User) WritePrompt(prompt string) (int, error) {
+	return utils.WritePrompt(self.conn, prompt, self.GetColorMode())
+}
+
 func UserNames(users []*User) []string {
 	names := make([]string, len(users))
 
@@ -3,12 +3,16 @@ package database
 import (
 	"github.com/Cristofori/kmud/datastore"
 	"github.com/Cristofori/kmud/utils"
+	"gopkg.in/mgo.v2/bson"
 )
 
 type Zone struct {
 	DbObject `bson:",inline"`
 
 	Name string
+
+	// New characters can start out in zones that have a start room
+	StartRoomId bson.ObjectId `bson:",omitempty"`
 }
 
 func NewZone(name string) *Zone {
@@ -41,6 +45,30 @@ func (self *Zone) SetName(name string) {
 	}
 }
 
+// SetStartRoom flags the zone as a starting area, new characters that pick it
+// start out in the given room. An empty id removes the flag.
+func (self *Zone) SetStartRoom(roomId bson.ObjectId) {
+	self.WriteLock()
+	defer self.WriteUnlock()
+
+	if roomId != self.StartRoomId {
+		self.StartRoomId = roomId
+		objectModified(self)
+	}
+}
+
+func (self *Zone) GetStartRoomId() bson.ObjectId {
+	self.ReadLock()
+	defer self.ReadUnlock()
+
+	return self.StartRoomId
+}
+
+// IsStartingArea returns true if new characters can start out in the zone
+func (self *Zone) IsStartingArea() bool {
+	return self.GetStartRoomId() != ""
+}
+
 type Zones []*Zone
 
 func (self Zones) Contains(z *Zone) bool {
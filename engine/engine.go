@@ -11,6 +11,13 @@ const (
 	RoamingProperty = "roaming"
 )
 
+// Time between moves of roaming NPCs
+var roamInterval = time.Second
+
+func SetRoamInterval(interval time.Duration) {
+	roamInterval = interval
+}
+
 func Start() {
 	for _, npc := range model.GetNpcs() {
 		manage(npc)
@@ -40,7 +47,7 @@ func Start() {
 
 func manage(npc *database.NonPlayerChar) {
 	go func() {
-		throttler := utils.NewThrottler(1 * time.Second)
+		throttler := utils.NewThrottler(roamInterval)
 
 		for {
 			if npc.GetRoaming() {
@@ -1,30 +1,64 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"github.com/Cristofori/kmud/config"
 	"github.com/Cristofori/kmud/server"
 	"os"
 	"os/signal"
 	"runtime"
+	"syscall"
 )
 
 func main() {
-	runtime.GOMAXPROCS(8)
+	cfg, err := config.Load(os.Args[1:])
 
-	go signalHandler()
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "kmud:", err)
+		os.Exit(2)
+	}
+
+	if cfg.Print {
+		cfg.Write(os.Stdout)
+		return
+	}
+
+	runtime.GOMAXPROCS(cfg.MaxProcs)
 
 	var s server.Server
+	s.Configure(cfg)
+
+	go signalHandler(&s)
+
 	s.Exec()
 }
 
-func signalHandler() {
+// signalHandler shuts the server down gracefully on SIGINT or SIGTERM, a
+// second one skips the rest of the countdown. SIGQUIT dumps the stacks of
+// every goroutine without stopping the server.
+func signalHandler(s *server.Server) {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 
-	for {
-		<-c
-		stack := make([]byte, 1024*10)
-		runtime.Stack(stack, true)
-		os.Stderr.Write(stack)
-		os.Exit(0)
+	stopping := false
+
+	for sig := range c {
+		if sig == syscall.SIGQUIT {
+			stack := make([]byte, 1024*1024)
+			n := runtime.Stack(stack, true)
+			os.Stderr.Write(stack[:n])
+		} else if stopping {
+			go s.Shutdown()
+		} else {
+			stopping = true
+
+			go func() {
+				s.Shutdown()
+				os.Exit(0)
+			}()
+		}
 	}
 }
@@ -0,0 +1,193 @@
+// Package limiter counts failures (e.g. failed logins) against a key such as
+// a username or an address. Every failure makes the next attempt wait twice
+// as long as the previous one, and once too many have piled up the key is
+// locked out for a while.
+//
+// A key's failures are forgotten once it's gone a full lockout period without
+// any new ones. Sweep has to be called now and then to let go of the keys that
+// never come back.
+package limiter
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Delays before the next attempt grow from baseDelay, doubling after each
+// failure, up to maxDelay
+const (
+	baseDelay = time.Second
+	maxDelay  = 30 * time.Second
+)
+
+type Limiter struct {
+	mutex   sync.Mutex
+	entries map[string]*entry
+
+	limit   int
+	lockout time.Duration
+
+	// Replaced by tests
+	now func() time.Time
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Entry describes a key with failures against it
+type Entry struct {
+	Key         string
+	Failures    int
+	LockedUntil time.Time // Zero if the key isn't locked
+}
+
+// New returns a Limiter that locks a key out for the given duration once it
+// reaches limit failures
+func New(limit int, lockout time.Duration) *Limiter {
+	var limiter Limiter
+	limiter.entries = map[string]*entry{}
+	limiter.limit = limit
+	limiter.lockout = lockout
+	limiter.now = time.Now
+	return &limiter
+}
+
+// get returns the entry for the key, or nil if it has none (or its failures
+// have been forgotten). Must be called with the mutex held.
+func (self *Limiter) get(key string) *entry {
+	e := self.entries[key]
+
+	if e == nil {
+		return nil
+	}
+
+	now := self.now()
+
+	if now.After(e.lockedUntil) && now.Sub(e.lastFailure) > self.lockout {
+		delete(self.entries, key)
+		return nil
+	}
+
+	return e
+}
+
+// Fail records a failure against the key, and returns how long to wait before
+// the next attempt should be allowed
+func (self *Limiter) Fail(key string) time.Duration {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	e := self.get(key)
+
+	if e == nil {
+		e = &entry{}
+		self.entries[key] = e
+	}
+
+	now := self.now()
+
+	e.failures++
+	e.lastFailure = now
+
+	if e.failures >= self.limit && now.After(e.lockedUntil) {
+		e.lockedUntil = now.Add(self.lockout)
+	}
+
+	return delay(e.failures)
+}
+
+func delay(failures int) time.Duration {
+	d := baseDelay
+
+	for i := 1; i < failures && d < maxDelay; i++ {
+		d *= 2
+	}
+
+	if d > maxDelay {
+		d = maxDelay
+	}
+
+	return d
+}
+
+// Locked returns how much longer the key is locked out for, zero if it isn't
+func (self *Limiter) Locked(key string) time.Duration {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	e := self.get(key)
+
+	if e == nil {
+		return 0
+	}
+
+	remaining := e.lockedUntil.Sub(self.now())
+
+	if remaining < 0 {
+		return 0
+	}
+
+	return remaining
+}
+
+// Reset forgets every failure against the key, unlocking it
+func (self *Limiter) Reset(key string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	delete(self.entries, key)
+}
+
+// Sweep forgets the failures of every key that's gone a full lockout period
+// without any new ones
+func (self *Limiter) Sweep() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for key := range self.entries {
+		self.get(key)
+	}
+}
+
+// Entries returns every key with failures against it, sorted by key
+func (self *Limiter) Entries() []Entry {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var entries []Entry
+
+	for key := range self.entries {
+		if e := self.get(key); e != nil {
+			var locked time.Time
+			if e.lockedUntil.After(self.now()) {
+				locked = e.lockedUntil
+			}
+
+			entries = append(entries, Entry{Key: key, Failures: e.failures, LockedUntil: locked})
+		}
+	}
+
+	sort.Sort(byKey(entries))
+
+	return entries
+}
+
+type byKey []Entry
+
+func (self byKey) Len() int {
+	return len(self)
+}
+
+func (self byKey) Less(i, j int) bool {
+	return self[i].Key < self[j].Key
+}
+
+func (self byKey) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+// vim: nocindent
@@ -0,0 +1,117 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (self *clock) advance(d time.Duration) {
+	self.now = self.now.Add(d)
+}
+
+func newTestLimiter(limit int, lockout time.Duration) (*Limiter, *clock) {
+	c := &clock{now: time.Unix(1000000, 0)}
+	limiter := New(limit, lockout)
+	limiter.now = func() time.Time { return c.now }
+	return limiter, c
+}
+
+func Test_Fail(t *testing.T) {
+	limiter, _ := newTestLimiter(10, time.Hour)
+
+	want := []time.Duration{1, 2, 4, 8, 16, 30, 30}
+
+	for i, w := range want {
+		if got := limiter.Fail("bob"); got != w*time.Second {
+			t.Errorf("Failure %v: Fail() == %v, want %v", i+1, got, w*time.Second)
+		}
+	}
+
+	if got := limiter.Fail("alice"); got != time.Second {
+		t.Errorf("Keys should be counted separately, Fail() == %v", got)
+	}
+}
+
+func Test_Locked(t *testing.T) {
+	limiter, clock := newTestLimiter(3, 10*time.Minute)
+
+	limiter.Fail("bob")
+	limiter.Fail("bob")
+
+	if locked := limiter.Locked("bob"); locked != 0 {
+		t.Errorf("Locked() == %v before the limit was reached", locked)
+	}
+
+	limiter.Fail("bob")
+
+	if locked := limiter.Locked("bob"); locked != 10*time.Minute {
+		t.Errorf("Locked() == %v, want %v", locked, 10*time.Minute)
+	}
+
+	// More failures while locked don't extend the lockout
+	clock.advance(time.Minute)
+	limiter.Fail("bob")
+
+	if locked := limiter.Locked("bob"); locked != 9*time.Minute {
+		t.Errorf("Locked() == %v, want %v", locked, 9*time.Minute)
+	}
+
+	clock.advance(9 * time.Minute)
+
+	if locked := limiter.Locked("bob"); locked != 0 {
+		t.Errorf("Locked() == %v after the lockout ended", locked)
+	}
+
+	// The failures are only forgotten after a full lockout period without any
+	clock.advance(2 * time.Minute)
+
+	if entries := limiter.Entries(); len(entries) != 0 {
+		t.Errorf("Entries() == %+v, the failures should have been forgotten", entries)
+	}
+}
+
+func Test_Reset(t *testing.T) {
+	limiter, clock := newTestLimiter(1, time.Hour)
+
+	limiter.Fail("bob")
+	clock.advance(time.Minute)
+	limiter.Fail("alice")
+
+	entries := limiter.Entries()
+
+	if len(entries) != 2 || entries[0].Key != "alice" || entries[1].Key != "bob" ||
+		entries[1].Failures != 1 || !entries[1].LockedUntil.Equal(clock.now.Add(59*time.Minute)) {
+		t.Errorf("Entries() == %+v", entries)
+	}
+
+	limiter.Reset("bob")
+
+	if locked := limiter.Locked("bob"); locked != 0 {
+		t.Errorf("Locked() == %v after Reset()", locked)
+	}
+
+	if got := limiter.Fail("bob"); got != time.Second {
+		t.Errorf("Fail() == %v after Reset(), want %v", got, time.Second)
+	}
+}
+
+// vim: nocindent
+
+func Test_Sweep(t *testing.T) {
+	limiter, clock := newTestLimiter(3, 10*time.Minute)
+
+	limiter.Fail("old")
+	clock.advance(6 * time.Minute)
+	limiter.Fail("new")
+	clock.advance(6 * time.Minute)
+
+	limiter.Sweep()
+
+	if len(limiter.entries) != 1 || limiter.entries["new"] == nil {
+		t.Errorf("Sweep() left %v entries, want just \"new\"", len(limiter.entries))
+	}
+}
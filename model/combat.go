@@ -59,9 +59,16 @@ func InCombat(character *database.Character) bool {
 	return false
 }
 
+// Time between combat rounds
+var combatInterval = 3 * time.Second
+
+func SetCombatInterval(interval time.Duration) {
+	combatInterval = interval
+}
+
 func combatLoop() {
 	for {
-		time.Sleep(3 * time.Second)
+		time.Sleep(combatInterval)
 
 		fightsMutex.RLock()
 		for a, d := range fights {
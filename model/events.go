@@ -20,9 +20,17 @@ func Login(character *database.PlayerChar) {
 
 func Logout(character *database.PlayerChar) {
 	character.SetOnline(false)
+	character.SetLinkDead(false)
 	queueEvent(LogoutEvent{character})
 }
 
+// SetLinkDead marks the character as having lost its connection, or as having
+// been reconnected
+func SetLinkDead(character *database.PlayerChar, linkDead bool) {
+	character.SetLinkDead(linkDead)
+	queueEvent(LinkDeadEvent{character, linkDead})
+}
+
 func Register() chan Event {
 	listener := make(chan Event, 100)
 
@@ -115,6 +123,10 @@ const (
 	CombatStopEventType  EventType = iota
 	CombatEventType      EventType = iota
 	TimerEventType       EventType = iota
+	SystemEventType      EventType = iota
+	LinkDeadEventType    EventType = iota
+	KickEventType        EventType = iota
+	JailEventType        EventType = iota
 )
 
 type Event interface {
@@ -195,6 +207,30 @@ type CombatEvent struct {
 type TimerEvent struct {
 }
 
+// SystemEvent is a message from the server itself, sent to everyone
+type SystemEvent struct {
+	Message string
+}
+
+type LinkDeadEvent struct {
+	Character *database.PlayerChar
+	LinkDead  bool
+}
+
+// KickEvent throws the user's characters out of the game, e.g. because the
+// user has been banned
+type KickEvent struct {
+	User    *database.User
+	Message string
+}
+
+// JailEvent tells a character that it's been taken to jail, or released
+type JailEvent struct {
+	Character *database.PlayerChar
+	Room      *database.Room
+	Jailed    bool
+}
+
 func (self BroadcastEvent) Type() EventType {
 	return BroadcastEventType
 }
@@ -411,6 +447,66 @@ func (self TimerEvent) IsFor(receiver *database.PlayerChar) bool {
 	return true
 }
 
+// System
+func (self SystemEvent) Type() EventType {
+	return SystemEventType
+}
+
+func (self SystemEvent) ToString(receiver *database.Character) string {
+	return utils.Colorize(utils.ColorYellow, ">> "+self.Message)
+}
+
+func (self SystemEvent) IsFor(receiver *database.PlayerChar) bool {
+	return true
+}
+
+// LinkDead
+func (self LinkDeadEvent) Type() EventType {
+	return LinkDeadEventType
+}
+
+func (self LinkDeadEvent) ToString(receiver *database.Character) string {
+	if self.LinkDead {
+		return fmt.Sprintf("%s has lost their link", self.Character.GetName())
+	}
+
+	return fmt.Sprintf("%s has reconnected", self.Character.GetName())
+}
+
+func (self LinkDeadEvent) IsFor(receiver *database.PlayerChar) bool {
+	return receiver.GetId() != self.Character.GetId()
+}
+
+// Kick
+func (self KickEvent) Type() EventType {
+	return KickEventType
+}
+
+func (self KickEvent) ToString(receiver *database.Character) string {
+	return utils.Colorize(utils.ColorRed, self.Message)
+}
+
+func (self KickEvent) IsFor(receiver *database.PlayerChar) bool {
+	return receiver.GetUserId() == self.User.GetId()
+}
+
+// Jail
+func (self JailEvent) Type() EventType {
+	return JailEventType
+}
+
+func (self JailEvent) ToString(receiver *database.Character) string {
+	if self.Jailed {
+		return utils.Colorize(utils.ColorRed, "You've been taken to jail")
+	}
+
+	return utils.Colorize(utils.ColorGreen, "You've been released from jail")
+}
+
+func (self JailEvent) IsFor(receiver *database.PlayerChar) bool {
+	return receiver.GetId() == self.Character.GetId()
+}
+
 // Create
 func (self CreateEvent) Type() EventType {
 	return CreateEventType
@@ -7,6 +7,7 @@ import (
 	db "github.com/Cristofori/kmud/database"
 	ds "github.com/Cristofori/kmud/datastore"
 	"github.com/Cristofori/kmud/utils"
+	"time"
 )
 
 // CreateUser creates a new User object in the database and adds it to the model.
@@ -48,18 +49,71 @@ func GetUserByName(username string) *db.User {
 	return nil
 }
 
+// UserNameTaken returns true if there's a user whose name could be mistaken
+// for the given one
+func UserNameTaken(name string) bool {
+	skeleton := utils.NameSkeleton(name)
+
+	for _, user := range GetUsers() {
+		if utils.NameSkeleton(user.GetName()) == skeleton {
+			return true
+		}
+	}
+
+	return false
+}
+
 func DeleteUserId(userId bson.ObjectId) {
 	DeleteUser(GetUser(userId))
 }
 
-// Removes the given User from the model. Removes it from the database as well.
+// DeleteUser deletes the given User along with its characters. They're kept
+// as tombstones, hidden from everything else in the model, until they're
+// either restored or purged. A user that's online is thrown out first.
 func DeleteUser(user *db.User) {
+	kick(user, "Your account has been deleted")
+
+	now := time.Now()
+
 	for _, character := range GetUserCharacters(user) {
-		DeletePlayerCharacter(character)
+		character.MarkDeleted(now)
 	}
 
-	ds.Remove(user)
-	utils.HandleError(db.DeleteObject(user))
+	user.MarkDeleted(now)
+}
+
+// GetDeletedUsers returns the users that are deleted but not purged yet
+func GetDeletedUsers() db.Users {
+	var users db.Users
+
+	for _, id := range db.FindDeleted(db.UserType) {
+		users = append(users, ds.Get(id).(*db.User))
+	}
+
+	return users
+}
+
+// RestoreUser brings back a deleted user, along with the characters that were
+// deleted with it. Fails if the name has been taken since.
+func RestoreUser(user *db.User) error {
+	if !user.IsDeleted() {
+		return errors.New("That user isn't deleted")
+	}
+
+	if UserNameTaken(user.GetName()) {
+		return errors.New("Another user has taken that name")
+	}
+
+	deleted := user.DeletedAt()
+
+	for _, pc := range GetDeletedPlayerCharacters() {
+		if pc.GetUserId() == user.GetId() && pc.DeletedAt().Equal(deleted) && !CharacterNameTaken(pc.GetName()) {
+			pc.Restore()
+		}
+	}
+
+	user.Restore()
+	return nil
 }
 
 // GetPlayerCharacter returns the Character object associated the given Id
@@ -87,6 +141,26 @@ func GetCharacterByName(name string) *db.Character {
 	return nil
 }
 
+// CharacterNameTaken returns true if there's a character, player or NPC,
+// whose name could be mistaken for the given one
+func CharacterNameTaken(name string) bool {
+	skeleton := utils.NameSkeleton(name)
+
+	for _, id := range db.FindAll(db.PcType) {
+		if utils.NameSkeleton(ds.Get(id).(*db.PlayerChar).GetName()) == skeleton {
+			return true
+		}
+	}
+
+	for _, npc := range GetNpcs() {
+		if utils.NameSkeleton(npc.GetName()) == skeleton {
+			return true
+		}
+	}
+
+	return false
+}
+
 // GetPlayerCharacaterByName searches for a character with the given name. Returns a
 // character object, or nil if it wasn't found.
 func GetPlayerCharacterByName(name string) *db.PlayerChar {
@@ -241,11 +315,65 @@ func DeleteNpcId(id bson.ObjectId) {
 	DeleteNpc(GetNpc(id))
 }
 
-// DeletePlayerCharacter removes the character (either NPC or player-controlled)
-// associated with the given id from the model and from the database
+// DeletePlayerCharacter deletes the given player character, keeping it as a
+// tombstone until it's either restored or purged
 func DeletePlayerCharacter(pc *db.PlayerChar) {
-	ds.Remove(pc)
-	utils.HandleError(db.DeleteObject(pc))
+	pc.MarkDeleted(time.Now())
+}
+
+// GetDeletedPlayerCharacters returns the player characters that are deleted
+// but not purged yet
+func GetDeletedPlayerCharacters() db.PlayerCharList {
+	var pcs db.PlayerCharList
+
+	for _, id := range db.FindDeleted(db.PcType) {
+		pcs = append(pcs, ds.Get(id).(*db.PlayerChar))
+	}
+
+	return pcs
+}
+
+// RestorePlayerCharacter brings back a deleted player character. Fails if the
+// name has been taken since, or if its user is deleted as well.
+func RestorePlayerCharacter(pc *db.PlayerChar) error {
+	if !pc.IsDeleted() {
+		return errors.New("That character isn't deleted")
+	}
+
+	if CharacterNameTaken(pc.GetName()) {
+		return errors.New("Another character has taken that name")
+	}
+
+	if !ds.ContainsId(pc.GetUserId()) || GetUser(pc.GetUserId()).IsDeleted() {
+		return errors.New("The character's user is deleted, restore the user first")
+	}
+
+	pc.Restore()
+	return nil
+}
+
+// PurgeDeleted removes the users and player characters that were deleted
+// before the given time from the model and the database for good. Returns the
+// number of objects purged.
+func PurgeDeleted(before time.Time) int {
+	purged := 0
+
+	purge := func(obj ds.Identifiable, deleted time.Time) {
+		if deleted.Before(before) {
+			DeleteObject(obj)
+			purged++
+		}
+	}
+
+	for _, pc := range GetDeletedPlayerCharacters() {
+		purge(pc, pc.DeletedAt())
+	}
+
+	for _, user := range GetDeletedUsers() {
+		purge(user, user.DeletedAt())
+	}
+
+	return purged
 }
 
 func DeleteNpc(npc *db.NonPlayerChar) {
@@ -325,6 +453,38 @@ func GetZones() db.Zones {
 	return zones
 }
 
+// GetStartingZones returns the zones that new characters can start out in,
+// those with a start room that still exists
+func GetStartingZones() db.Zones {
+	var zones db.Zones
+
+	for _, zone := range GetZones() {
+		if zone.IsStartingArea() && ds.ContainsId(zone.GetStartRoomId()) {
+			zones = append(zones, zone)
+		}
+	}
+
+	return zones
+}
+
+// StartingRoom returns the room that new characters starting out in the zone
+// are put in, the first starting area's if the zone is nil. Without a starting
+// area they go to the first room there is.
+func StartingRoom(zone *db.Zone) *db.Room {
+	zones := GetStartingZones()
+
+	if zone == nil && len(zones) > 0 {
+		zone = zones[0]
+	}
+
+	// The zone's start room could have been removed in the meantime
+	if zone != nil && zones.Contains(zone) {
+		return GetRoom(zone.GetStartRoomId())
+	}
+
+	return GetRooms()[0]
+}
+
 // CreateZone creates a new Zone object in the database and adds it to the model.
 // A pointer to the new Zone object is returned.
 func CreateZone(name string) (*db.Zone, error) {
@@ -532,6 +692,14 @@ func Init(session db.Session, dbName string) error {
 		ds.Set(item)
 	}
 
+	bans := []*db.Ban{}
+	err = db.RetrieveObjects(db.BanType, &bans)
+	utils.HandleError(err)
+
+	for _, ban := range bans {
+		ds.Set(ban)
+	}
+
 	// Start the event loop
 	go eventLoop()
 
@@ -544,18 +712,34 @@ func Init(session db.Session, dbName string) error {
 // MoveCharacter attempts to move the character to the given coordinates
 // specific by location. Returns an error if there is no room to move to.
 func MoveCharacterToLocation(character *db.Character, zone *db.Zone, location db.Coordinate) (*db.Room, error) {
+	if err := CheckJailed(character); err != nil {
+		return nil, err
+	}
+
 	newRoom := GetRoomByLocation(location, zone)
 
 	if newRoom == nil {
 		return nil, errors.New("Invalid location")
 	}
 
-	MoveCharacterToRoom(character, newRoom)
+	moveCharacterToRoom(character, newRoom)
 	return newRoom, nil
 }
 
-// MoveCharacterTo room moves the character to the given room
-func MoveCharacterToRoom(character *db.Character, newRoom *db.Room) {
+// MoveCharacterToRoom moves the character to the given room, unless it's in
+// jail
+func MoveCharacterToRoom(character *db.Character, newRoom *db.Room) error {
+	if err := CheckJailed(character); err != nil {
+		return err
+	}
+
+	moveCharacterToRoom(character, newRoom)
+	return nil
+}
+
+// moveCharacterToRoom moves the character without checking whether it's
+// allowed to go anywhere, for putting it in and taking it out of jail
+func moveCharacterToRoom(character *db.Character, newRoom *db.Room) {
 	oldRoomId := character.GetRoomId()
 	character.SetRoomId(newRoom.GetId())
 
@@ -570,6 +754,10 @@ func MoveCharacterToRoom(character *db.Character, newRoom *db.Room) {
 // room connected to it, then a room is automatically created for the character
 // to move in to.
 func MoveCharacter(character *db.Character, direction db.Direction) (*db.Room, error) {
+	if err := CheckJailed(character); err != nil {
+		return nil, err
+	}
+
 	room := GetRoom(character.GetRoomId())
 
 	if room == nil {
@@ -625,24 +813,53 @@ func MoveCharacter(character *db.Character, direction db.Direction) (*db.Room, e
 	return MoveCharacterToLocation(character, GetZone(room.GetZoneId()), room.GetLocation())
 }
 
-// BroadcastMessage sends a message to all users that are logged in
-func BroadcastMessage(from *db.Character, message string) {
+// BroadcastMessage sends a message to all users that are logged in. Fails if
+// the character is muted.
+func BroadcastMessage(from *db.Character, message string) error {
+	if err := checkMuted(from); err != nil {
+		return err
+	}
+
 	queueEvent(BroadcastEvent{from, message})
+	return nil
+}
+
+// SystemMessage sends a message from the server to all users that are logged in
+func SystemMessage(message string) {
+	queueEvent(SystemEvent{message})
 }
 
-// Tell sends a message to the specified character
-func Tell(from *db.Character, to *db.Character, message string) {
+// Tell sends a message to the specified character. Fails if the sender is
+// muted.
+func Tell(from *db.Character, to *db.Character, message string) error {
+	if err := checkMuted(from); err != nil {
+		return err
+	}
+
 	queueEvent(TellEvent{from, to, message})
+	return nil
 }
 
-// Say sends a message to all characters in the given character's room
-func Say(from *db.Character, message string) {
+// Say sends a message to all characters in the given character's room. Fails
+// if the character is muted.
+func Say(from *db.Character, message string) error {
+	if err := checkMuted(from); err != nil {
+		return err
+	}
+
 	queueEvent(SayEvent{from, message})
+	return nil
 }
 
-// Emote sends an emote message to all characters in the given character's room
-func Emote(from *db.Character, message string) {
+// Emote sends an emote message to all characters in the given character's
+// room. Fails if the character is muted.
+func Emote(from *db.Character, message string) error {
+	if err := checkMuted(from); err != nil {
+		return err
+	}
+
 	queueEvent(EmoteEvent{from, message})
+	return nil
 }
 
 // ZoneCorners returns cordinates that indiate the highest and lowest points of
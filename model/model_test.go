@@ -6,6 +6,7 @@ import (
 	"github.com/Cristofori/kmud/datastore"
 	"github.com/Cristofori/kmud/testutils"
 	tu "github.com/Cristofori/kmud/testutils"
+	"net"
 	"testing"
 	"time"
 )
@@ -71,6 +72,15 @@ func Test_UserFunctions(t *testing.T) {
 	userList = GetUsers()
 	tu.Assert(!userList.Contains(user1), t, "GetUsers() shouldn't have user1 in it anymore")
 	tu.Assert(len(GetUserCharacters(user1)) == 0, t, "Deleting a user should have deleted its characters")
+	tu.Assert(GetDeletedUsers().Contains(user1), t, "GetDeletedUsers() didn't return user1")
+
+	tu.Assert(RestoreUser(user1) == nil, t, "RestoreUser() failed")
+	tu.Assert(GetUserByName(name1) == user1, t, "RestoreUser() failed to restore user1")
+	tu.Assert(len(GetUserCharacters(user1)) == 1, t, "Restoring a user should have restored its characters")
+
+	DeleteUser(user1)
+	PurgeDeleted(time.Now().Add(time.Second))
+	tu.Assert(!GetDeletedUsers().Contains(user1), t, "PurgeDeleted() failed to purge user1")
 
 	_cleanup(t)
 }
@@ -127,6 +137,74 @@ func Test_RoomFunctions(t *testing.T) {
 	_cleanup(t)
 }
 
+func Test_Bans(t *testing.T) {
+	cidr := func(text string) *net.IPNet {
+		_, network, _ := net.ParseCIDR(text)
+		return network
+	}
+
+	BanAddresses(cidr("10.0.0.0/8"), database.NewSanction(0, "test", "admin"))
+	BanAddresses(cidr("192.168.1.7/32"), database.Sanction{Since: time.Now().Add(-2 * time.Hour), Until: time.Now().Add(-time.Hour)})
+	BanAddresses(cidr("2001:db8::/32"), database.NewSanction(time.Hour, "test", "admin"))
+
+	var tests = []struct {
+		address string
+		banned  bool
+	}{
+		{"10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"192.168.1.7", false}, // The ban has run out
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+	}
+
+	for _, test := range tests {
+		ban := GetBanFor(net.ParseIP(test.address))
+		tu.Assert((ban != nil) == test.banned, t, "GetBanFor(", test.address, ") ==", ban, "want banned:", test.banned)
+	}
+
+	tu.Assert(len(GetBans()) == 2, t, "GetBans() shouldn't include bans that have run out")
+
+	PurgeExpiredBans()
+	tu.Assert(len(database.FindAll(database.BanType)) == 2, t, "PurgeExpiredBans() failed to purge the expired ban")
+
+	_cleanup(t)
+}
+
+func Test_Jail(t *testing.T) {
+	zone, _ := CreateZone("jailZone")
+	room, _ := CreateRoom(zone, database.Coordinate{X: 0, Y: 0, Z: 0})
+	jail, _ := CreateRoom(zone, database.Coordinate{X: 5, Y: 0, Z: 0})
+	room.SetExitEnabled(database.DirectionEast, true)
+
+	user := CreateUser("prisoner", "password")
+	pc := CreatePlayerCharacter("prisoner", user, room)
+	pc.SetOnline(true)
+
+	err := Impose(user, database.SanctionJail, database.NewSanction(0, "test", "admin"))
+	tu.Assert(err != nil, t, "Jailing without a jail room should have failed")
+
+	jail.SetJail(true)
+	database.Flush()
+
+	err = Impose(user, database.SanctionJail, database.NewSanction(0, "test", "admin"))
+	tu.Assert(err == nil, t, "Impose() failed:", err)
+	tu.Assert(pc.GetRoomId() == jail.GetId(), t, "Jailing didn't take the character to jail")
+	tu.Assert(!EnforceJail(pc), t, "EnforceJail() shouldn't move a character that's already in jail")
+
+	_, err = MoveCharacter(&pc.Character, database.DirectionEast)
+	tu.Assert(err != nil, t, "A jailed character shouldn't be able to walk out")
+
+	err = MoveCharacterToRoom(&pc.Character, room)
+	tu.Assert(err != nil && pc.GetRoomId() == jail.GetId(), t, "A jailed character shouldn't be able to be moved out")
+
+	tu.Assert(Lift(user, database.SanctionJail), t, "Lift() failed")
+	tu.Assert(pc.GetRoomId() == room.GetId(), t, "Releasing didn't take the character back")
+	tu.Assert(!EnforceJail(pc), t, "EnforceJail() shouldn't move a character that's been released")
+
+	_cleanup(t)
+}
+
 func Test_RoomAndZoneFunctions(t *testing.T) {
 	// ZoneCorners
 	// GetRoomsInZone
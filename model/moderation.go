@@ -0,0 +1,218 @@
+package model
+
+import (
+	"errors"
+	db "github.com/Cristofori/kmud/database"
+	ds "github.com/Cristofori/kmud/datastore"
+	"github.com/Cristofori/kmud/utils"
+	"net"
+	"time"
+)
+
+// userOf returns the user that plays the character, nil for NPCs
+func userOf(character *db.Character) *db.User {
+	pc, ok := ds.Get(character.GetId()).(*db.PlayerChar)
+
+	if !ok || !ds.ContainsId(pc.GetUserId()) {
+		return nil
+	}
+
+	return GetUser(pc.GetUserId())
+}
+
+// sanctioned returns the sanction of the given kind that the character's user
+// is under, false if there isn't one
+func sanctioned(character *db.Character, kind db.SanctionKind) (db.Sanction, bool) {
+	user := userOf(character)
+
+	if user == nil {
+		return db.Sanction{}, false
+	}
+
+	return user.GetSanction(kind)
+}
+
+// checkMuted returns an error if the character isn't allowed to talk
+func checkMuted(character *db.Character) error {
+	if sanction, muted := sanctioned(character, db.SanctionMute); muted {
+		return errors.New("You've been muted " + sanction.String())
+	}
+
+	return nil
+}
+
+// CheckJailed returns an error if the character is in jail, and so can't go
+// anywhere
+func CheckJailed(character *db.Character) error {
+	if sanction, jailed := sanctioned(character, db.SanctionJail); jailed {
+		return errors.New("You're in jail " + sanction.String())
+	}
+
+	return nil
+}
+
+// Impose puts a sanction on the user, which takes effect right away: banned
+// users are disconnected and jailed users are taken to jail. Fails if there's
+// no jail room to jail anyone in.
+func Impose(user *db.User, kind db.SanctionKind, sanction db.Sanction) error {
+	if kind == db.SanctionJail && GetJailRoom() == nil {
+		return errors.New("There's no jail room, a builder has to make one first")
+	}
+
+	user.Impose(kind, sanction)
+
+	switch kind {
+	case db.SanctionBan:
+		kick(user, "You've been banned "+sanction.String())
+	case db.SanctionJail:
+		for _, pc := range GetUserCharacters(user) {
+			if pc.IsOnline() {
+				EnforceJail(pc)
+			}
+		}
+	}
+
+	return nil
+}
+
+// kick throws the user out, whether they're in the game or still in the
+// menus. Their connection is closed right away, and the event ends a session
+// that's running on it, instead of leaving the character link-dead.
+func kick(user *db.User, message string) {
+	if conn := user.GetConnection(); user.Online() && conn != nil {
+		user.WriteLine(utils.Colorize(utils.ColorRed, message))
+		conn.Close()
+	}
+
+	queueEvent(KickEvent{User: user, Message: message})
+}
+
+// Lift removes a sanction from the user, releasing them if they were in jail.
+// Returns false if they weren't under such a sanction.
+func Lift(user *db.User, kind db.SanctionKind) bool {
+	lifted := user.Lift(kind)
+
+	if kind == db.SanctionJail {
+		for _, pc := range GetUserCharacters(user) {
+			if pc.IsOnline() {
+				EnforceJail(pc)
+			}
+		}
+	}
+
+	return lifted
+}
+
+// GetJailRoom returns the room that jailed characters are taken to, nil if
+// there isn't one
+func GetJailRoom() *db.Room {
+	for _, id := range db.Find(db.RoomType, "jail", true) {
+		return ds.Get(id).(*db.Room)
+	}
+
+	return nil
+}
+
+// EnforceJail takes the character to jail if its user is jailed, or back to
+// where it was taken from once the user isn't anymore. Returns true if the
+// character was moved.
+func EnforceJail(pc *db.PlayerChar) bool {
+	room := GetRoom(pc.GetRoomId())
+
+	if _, jailed := sanctioned(&pc.Character, db.SanctionJail); jailed {
+		jail := GetJailRoom()
+
+		if room.IsJail() || jail == nil {
+			return false
+		}
+
+		pc.SetJailedFromId(room.GetId())
+		moveCharacterToRoom(&pc.Character, jail)
+		queueEvent(JailEvent{Character: pc, Room: jail, Jailed: true})
+		return true
+	}
+
+	fromId := pc.GetJailedFromId()
+
+	if fromId == "" {
+		return false
+	}
+
+	pc.SetJailedFromId("")
+
+	// The room it came from could have been removed in the meantime
+	var dest *db.Room
+	if ds.ContainsId(fromId) {
+		dest = GetRoom(fromId)
+	} else {
+		dest = StartingRoom(nil)
+	}
+
+	moveCharacterToRoom(&pc.Character, dest)
+	queueEvent(JailEvent{Character: pc, Room: dest, Jailed: false})
+	return true
+}
+
+// BanAddresses bans the address range, disconnecting anyone connected from
+// it
+func BanAddresses(network *net.IPNet, sanction db.Sanction) *db.Ban {
+	ban := db.NewBan(network, sanction)
+
+	for _, user := range GetUsers() {
+		if !user.Online() || user.GetConnection() == nil {
+			continue
+		}
+
+		host, _, err := net.SplitHostPort(user.GetConnection().RemoteAddr().String())
+
+		if err == nil && network.Contains(net.ParseIP(host)) {
+			kick(user, "Your address has been banned "+sanction.String())
+		}
+	}
+
+	return ban
+}
+
+// GetBans returns the address range bans that are in effect
+func GetBans() []*db.Ban {
+	var bans []*db.Ban
+
+	now := time.Now()
+
+	for _, id := range db.FindAll(db.BanType) {
+		ban := ds.Get(id).(*db.Ban)
+
+		if ban.GetSanction().Active(now) {
+			bans = append(bans, ban)
+		}
+	}
+
+	return bans
+}
+
+// GetBanFor returns the ban on the range that the address is in, nil if it
+// isn't banned
+func GetBanFor(ip net.IP) *db.Ban {
+	for _, ban := range GetBans() {
+		if ban.Contains(ip) {
+			return ban
+		}
+	}
+
+	return nil
+}
+
+// PurgeExpiredBans removes address range bans that have run out
+func PurgeExpiredBans() {
+	now := time.Now()
+
+	for _, id := range db.FindAll(db.BanType) {
+		ban := ds.Get(id).(*db.Ban)
+
+		if !ban.GetSanction().Active(now) {
+			DeleteObject(ban)
+		}
+	}
+}
+
+// vim: nocindent
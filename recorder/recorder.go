@@ -0,0 +1,244 @@
+// Package recorder records the raw byte stream of a connection, in both
+// directions, so that a session can be replayed exactly as it happened.
+//
+// A recording file starts with a header:
+//
+//	magic        8 bytes   "KMUDREC1"
+//	start time   8 bytes   Unix time in nanoseconds, big endian
+//	address      2 bytes   Length of the remote address, big endian
+//	             n bytes   Remote address of the connection, e.g. "10.0.0.1:5312"
+//
+// Followed by any number of records, one for every Read or Write on the
+// connection:
+//
+//	offset       8 bytes   Nanoseconds since the start time, big endian
+//	direction    1 byte    'I' for input (client to server), 'O' for output (server to client)
+//	length       4 bytes   Length of the data, big endian
+//	data         n bytes   The bytes exactly as they were sent or received
+//
+// Output is recorded after compression, so a client that negotiated MCCP will
+// have a zlib stream in its output records.
+package recorder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const Magic = "KMUDREC1"
+
+// File extension used for recordings
+const Extension = ".kmudrec"
+
+type Direction byte
+
+const (
+	Input  Direction = 'I' // Client to server
+	Output Direction = 'O' // Server to client
+)
+
+type Header struct {
+	Start      time.Time
+	RemoteAddr string
+}
+
+type Record struct {
+	Offset    time.Duration
+	Direction Direction
+	Data      []byte
+}
+
+// Writer writes a recording, it's safe to use from multiple goroutines
+type Writer struct {
+	mutex sync.Mutex
+	w     io.Writer
+	start time.Time
+	err   error
+}
+
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	if len(header.RemoteAddr) > 0xFFFF {
+		return nil, errors.New("recorder: remote address is too long")
+	}
+
+	buf := make([]byte, 0, len(Magic)+10+len(header.RemoteAddr))
+	buf = append(buf, Magic...)
+	buf = appendUint64(buf, uint64(header.Start.UnixNano()))
+	buf = append(buf, byte(len(header.RemoteAddr)>>8), byte(len(header.RemoteAddr)))
+	buf = append(buf, header.RemoteAddr...)
+
+	if _, err := w.Write(buf); err != nil {
+		return nil, err
+	}
+
+	var writer Writer
+	writer.w = w
+	writer.start = header.Start
+	return &writer, nil
+}
+
+// WriteRecord records data that was sent in the given direction just now
+func (self *Writer) WriteRecord(direction Direction, data []byte) error {
+	return self.writeRecord(Record{time.Since(self.start), direction, data})
+}
+
+func (self *Writer) writeRecord(record Record) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.err != nil {
+		return self.err
+	}
+
+	buf := make([]byte, 0, 13+len(record.Data))
+	buf = appendUint64(buf, uint64(record.Offset))
+	buf = append(buf, byte(record.Direction))
+	buf = append(buf, byte(len(record.Data)>>24), byte(len(record.Data)>>16), byte(len(record.Data)>>8), byte(len(record.Data)))
+	buf = append(buf, record.Data...)
+
+	_, self.err = self.w.Write(buf)
+	return self.err
+}
+
+func appendUint64(buf []byte, value uint64) []byte {
+	var encoded [8]byte
+	binary.BigEndian.PutUint64(encoded[:], value)
+	return append(buf, encoded[:]...)
+}
+
+// Reader reads a recording one record at a time
+type Reader struct {
+	Header Header
+	r      *bufio.Reader
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	var reader Reader
+	reader.r = bufio.NewReader(r)
+
+	var fixed [len(Magic) + 10]byte
+	if _, err := io.ReadFull(reader.r, fixed[:]); err != nil {
+		return nil, err
+	}
+
+	if string(fixed[:len(Magic)]) != Magic {
+		return nil, errors.New("recorder: not a recording")
+	}
+
+	start := int64(binary.BigEndian.Uint64(fixed[len(Magic):]))
+	addrLen := binary.BigEndian.Uint16(fixed[len(Magic)+8:])
+
+	addr := make([]byte, addrLen)
+	if _, err := io.ReadFull(reader.r, addr); err != nil {
+		return nil, err
+	}
+
+	reader.Header = Header{time.Unix(0, start), string(addr)}
+	return &reader, nil
+}
+
+// Next returns the next record, or io.EOF once there are no more
+func (self *Reader) Next() (Record, error) {
+	var fixed [13]byte
+
+	if _, err := io.ReadFull(self.r, fixed[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("recorder: truncated record")
+		}
+		return Record{}, err
+	}
+
+	var record Record
+	record.Offset = time.Duration(binary.BigEndian.Uint64(fixed[:8]))
+	record.Direction = Direction(fixed[8])
+
+	record.Data = make([]byte, binary.BigEndian.Uint32(fixed[9:]))
+	if _, err := io.ReadFull(self.r, record.Data); err != nil {
+		return Record{}, errors.New("recorder: truncated record")
+	}
+
+	return record, nil
+}
+
+// Conn records everything that is read from and written to the connection it
+// wraps. Recording stops quietly if writing the recording fails, the
+// connection itself is left alone.
+type Conn struct {
+	net.Conn
+	writer *Writer
+	closer io.Closer
+}
+
+func NewConn(conn net.Conn, writer *Writer, closer io.Closer) *Conn {
+	return &Conn{conn, writer, closer}
+}
+
+func (self *Conn) Read(p []byte) (int, error) {
+	n, err := self.Conn.Read(p)
+
+	if n > 0 {
+		self.writer.WriteRecord(Input, p[:n])
+	}
+
+	return n, err
+}
+
+func (self *Conn) Write(p []byte) (int, error) {
+	n, err := self.Conn.Write(p)
+
+	if n > 0 {
+		self.writer.WriteRecord(Output, p[:n])
+	}
+
+	return n, err
+}
+
+func (self *Conn) Close() error {
+	err := self.Conn.Close()
+
+	if self.closer != nil {
+		self.closer.Close()
+	}
+
+	return err
+}
+
+// Start records the given connection to a new file in the given
+// directory, named after the current time and the connection's address
+func Start(conn net.Conn, dir string) (*Conn, error) {
+	start := time.Now()
+
+	addr := ""
+	if conn.RemoteAddr() != nil {
+		addr = conn.RemoteAddr().String()
+	}
+
+	name := fmt.Sprintf("%s-%s%s", start.Format("20060102-150405.000000000"),
+		strings.NewReplacer(":", "_", "/", "_", "[", "", "]", "").Replace(addr), Extension)
+
+	// Recordings hold everything the player typed, passwords included, so
+	// only the server's user gets to read them
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := NewWriter(file, Header{start, addr})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return NewConn(conn, writer, file), nil
+}
+
+// vim: nocindent
@@ -0,0 +1,153 @@
+package recorder
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_WriterReader(t *testing.T) {
+	var buf bytes.Buffer
+
+	start := time.Unix(1400000000, 12345)
+	writer, err := NewWriter(&buf, Header{start, "127.0.0.1:5000"})
+
+	if err != nil {
+		t.Fatalf("NewWriter() failed: %v", err)
+	}
+
+	records := []Record{
+		{time.Millisecond, Input, []byte{255, 253, 24}},
+		{2 * time.Second, Output, []byte("Welcome\r\n")},
+		{3 * time.Second, Input, []byte{}},
+	}
+
+	for _, record := range records {
+		writer.writeRecord(record)
+	}
+
+	reader, err := NewReader(&buf)
+
+	if err != nil {
+		t.Fatalf("NewReader() failed: %v", err)
+	}
+
+	if !reader.Header.Start.Equal(start) || reader.Header.RemoteAddr != "127.0.0.1:5000" {
+		t.Errorf("Header == %v, want %v", reader.Header, Header{start, "127.0.0.1:5000"})
+	}
+
+	for _, want := range records {
+		record, err := reader.Next()
+
+		if err != nil || record.Offset != want.Offset || record.Direction != want.Direction || !bytes.Equal(record.Data, want.Data) {
+			t.Errorf("Next() == %v, %v, want %v", record, err, want)
+		}
+	}
+
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("Next() at the end of the recording == %v, want EOF", err)
+	}
+}
+
+func Test_BadRecording(t *testing.T) {
+	if _, err := NewReader(bytes.NewBufferString("NOTAREC1xxxxxxxxxx")); err == nil {
+		t.Errorf("NewReader() accepted a file with the wrong magic")
+	}
+
+	var buf bytes.Buffer
+	writer, _ := NewWriter(&buf, Header{time.Now(), ""})
+	writer.WriteRecord(Output, []byte("cut short"))
+
+	reader, _ := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-2]))
+
+	if _, err := reader.Next(); err == nil || err == io.EOF {
+		t.Errorf("Next() on a truncated record == %v, want an error", err)
+	}
+}
+
+type closeRecorder struct {
+	closed bool
+}
+
+func (self *closeRecorder) Close() error {
+	self.closed = true
+	return nil
+}
+
+func Test_Conn(t *testing.T) {
+	server, client := net.Pipe()
+
+	var buf bytes.Buffer
+	var closer closeRecorder
+
+	writer, _ := NewWriter(&buf, Header{time.Now(), "pipe"})
+	conn := NewConn(server, writer, &closer)
+
+	go func() {
+		client.Write([]byte("look\r\n"))
+
+		response := make([]byte, 5)
+		io.ReadFull(client, response)
+	}()
+
+	input := make([]byte, 6)
+	io.ReadFull(conn, input)
+	conn.Write([]byte("Hello"))
+	conn.Close()
+
+	if !closer.closed {
+		t.Errorf("Closing the connection didn't close the recording")
+	}
+
+	reader, _ := NewReader(&buf)
+
+	var in, out []byte
+	for {
+		record, err := reader.Next()
+		if err != nil {
+			break
+		}
+
+		if record.Direction == Input {
+			in = append(in, record.Data...)
+		} else {
+			out = append(out, record.Data...)
+		}
+	}
+
+	if string(in) != "look\r\n" || string(out) != "Hello" {
+		t.Errorf("Recorded input %q and output %q, want %q and %q", in, out, "look\r\n", "Hello")
+	}
+}
+
+// vim: nocindent
+
+func Test_StartPermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	conn, err := Start(server, dir)
+	if err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	conn.Close()
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("Start() created %v files, want 1", len(files))
+	}
+
+	if mode := files[0].Mode().Perm(); mode != 0600 {
+		t.Errorf("Recording mode == %v, want 0600", mode)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Cristofori/kmud/audit"
+	"github.com/Cristofori/kmud/database"
+	"github.com/Cristofori/kmud/utils"
+	"time"
+	"unicode/utf8"
+)
+
+// askNewPassword has the user type a new password twice. Returns an empty
+// string if they give up by entering nothing.
+func (self *Server) askNewPassword(conn *wrappedConnection, prompt string) string {
+	minPasswordLength := self.config.MinPasswordLength
+
+	conn.hideInput(true)
+	defer conn.hideInput(false)
+
+	for {
+		pass1 := utils.GetRawUserInputSuffix(conn, prompt, "\r\n", utils.ColorModeNone)
+
+		if pass1 == "" {
+			return ""
+		}
+
+		if utf8.RuneCountInString(pass1) < minPasswordLength {
+			utils.WriteLine(conn, fmt.Sprintf("Passwords must be at least %v letters in length", minPasswordLength), utils.ColorModeNone)
+			continue
+		}
+
+		pass2 := utils.GetRawUserInputSuffix(conn, "Confirm password: ", "\r\n", utils.ColorModeNone)
+
+		if pass1 != pass2 {
+			utils.WriteLine(conn, "Passwords do not match", utils.ColorModeNone)
+			continue
+		}
+
+		return pass1
+	}
+}
+
+// changePassword replaces the user's password. The current one is asked for
+// first, unless it's a temporary password that they just logged in with.
+// Wrong guesses count as failed logins. Returns true if the password was
+// changed.
+func (self *Server) changePassword(conn *wrappedConnection, user *database.User) bool {
+	addr := remoteHost(conn)
+
+	if !user.MustChangePassword() {
+		if self.addressLocked(conn) {
+			return false
+		}
+
+		conn.hideInput(true)
+		current := utils.GetRawUserInputSuffix(conn, "Current password: ", "\r\n", utils.ColorModeNone)
+		conn.hideInput(false)
+
+		if current == "" {
+			return false
+		}
+
+		if !user.VerifyPassword(current) {
+			audit.Log(user.GetName(), addr, audit.PasswordChangeFailed, user.GetName())
+			time.Sleep(self.loginFailed(conn, user.GetName()))
+			user.WriteLine("Wrong password")
+			return false
+		}
+	}
+
+	for {
+		password := self.askNewPassword(conn, "New password: ")
+
+		if password == "" {
+			return false
+		}
+
+		// Otherwise a temporary password could be kept by typing it again
+		if user.VerifyPassword(password) {
+			user.WriteLine("The new password has to be different from the current one")
+			continue
+		}
+
+		user.SetPassword(password)
+		audit.Log(user.GetName(), addr, audit.PasswordChange, user.GetName())
+		user.WriteLine("Your password has been changed")
+		return true
+	}
+}
+
+// forcePasswordChange makes a user who logged in with a temporary password
+// choose a new one before going any further. Returns false if they didn't.
+func (self *Server) forcePasswordChange(conn *wrappedConnection, user *database.User) bool {
+	if !user.MustChangePassword() {
+		return true
+	}
+
+	user.WriteLine("You're using a temporary password, please choose a new one")
+
+	if self.changePassword(conn, user) {
+		return true
+	}
+
+	user.WriteLine("You have to choose a new password before you can continue")
+	return false
+}
+
+// resetPassword gives the user a temporary password, which the admin passes
+// on to them, and clears any lockout of their account
+func (self *Server) resetPassword(conn *wrappedConnection, admin *database.User, user *database.User) {
+	password := utils.TemporaryPassword()
+
+	user.SetTemporaryPassword(password)
+	self.accountLimiter.Reset(accountKey(user.GetName()))
+
+	audit.Log(admin.GetName(), remoteHost(conn), audit.PasswordReset, user.GetName())
+
+	admin.WriteLine(fmt.Sprintf("Temporary password for %s: %s", user.GetName(), password))
+	admin.WriteLine("They'll have to change it when they next log in")
+}
+
+// vim: nocindent
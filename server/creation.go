@@ -0,0 +1,188 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Cristofori/kmud/database"
+	"github.com/Cristofori/kmud/model"
+	"github.com/Cristofori/kmud/utils"
+)
+
+// Points that players spend on attributes when creating a character, on top
+// of database.BaseAttribute for each of them
+const attributePoints = 6
+
+// newPlayer walks the user through creating a character: its name, its
+// attributes and the zone it starts out in, followed by a review of all of
+// that before the character is actually created. Returns nil if the user
+// gives up along the way.
+func (self *Server) newPlayer(conn *wrappedConnection, user *database.User) *database.PlayerChar {
+	limit := self.config.MaxCharacters
+
+	if limit > 0 && len(model.GetUserCharacters(user)) >= limit {
+		user.WriteLine(fmt.Sprintf("You already have %v characters, which is as many as you can have", limit))
+		return nil
+	}
+
+	name := askCharacterName(user)
+	if name == "" {
+		return nil
+	}
+
+	attributes, ok := chooseAttributes(conn, user)
+	if !ok {
+		return nil
+	}
+
+	zone, ok := chooseStartingZone(conn, user)
+	if !ok {
+		return nil
+	}
+
+	for {
+		user.WriteLine("")
+		user.WriteLine(utils.Colorize(utils.ColorWhite, "Name: ") + name)
+		user.WriteLine(utils.Colorize(utils.ColorWhite, "Attributes: ") + attributes.String())
+
+		if zone != nil {
+			user.WriteLine(utils.Colorize(utils.ColorWhite, "Starting area: ") + zone.GetName())
+		}
+
+		menu := utils.NewMenu("New character")
+		menu.AddAction("c", "Create")
+		menu.AddAction("n", "Change name")
+		menu.AddAction("a", "Change attributes")
+
+		if len(model.GetStartingZones()) > 1 {
+			menu.AddAction("s", "Change starting area")
+		}
+
+		choice, _ := menu.Exec(conn, user.GetColorMode())
+
+		switch choice {
+		case "":
+			return nil
+		case "n":
+			if newName := askCharacterName(user); newName != "" {
+				name = newName
+			}
+		case "a":
+			if newAttributes, ok := chooseAttributes(conn, user); ok {
+				attributes = newAttributes
+			}
+		case "s":
+			if newZone, ok := chooseStartingZone(conn, user); ok {
+				zone = newZone
+			}
+		case "c":
+			// Someone else could have taken the name in the meantime
+			if model.CharacterNameTaken(name) {
+				user.WriteLine("That name is no longer available")
+				break
+			}
+
+			pc := model.CreatePlayerCharacter(name, user, model.StartingRoom(zone))
+			pc.SetAttributes(attributes)
+			return pc
+		}
+	}
+}
+
+func askCharacterName(user *database.User) string {
+	for {
+		name := user.GetInput("Desired character name: ")
+
+		if name == "" {
+			return ""
+		}
+
+		if model.CharacterNameTaken(name) {
+			user.WriteLine("That name is unavailable")
+		} else if err := utils.ValidateName(name); err != nil {
+			user.WriteLine(err.Error())
+		} else {
+			return name
+		}
+	}
+}
+
+// chooseAttributes has the user spend attributePoints on the character's
+// attributes. Returns false if the user gives up.
+func chooseAttributes(conn *wrappedConnection, user *database.User) (database.Attributes, bool) {
+	attributes := database.DefaultAttributes()
+	points := attributePoints
+
+	for {
+		menu := utils.NewMenu(fmt.Sprintf("Attributes (%v points left)", points))
+		menu.AddAction("s", fmt.Sprintf("Strength: %v", attributes.Strength))
+		menu.AddAction("d", fmt.Sprintf("Dexterity: %v", attributes.Dexterity))
+		menu.AddAction("c", fmt.Sprintf("Constitution: %v", attributes.Constitution))
+		menu.AddAction("i", fmt.Sprintf("Intelligence: %v", attributes.Intelligence))
+		menu.AddAction("r", "Reset")
+		menu.AddAction("n", "Next")
+
+		choice, _ := menu.Exec(conn, user.GetColorMode())
+
+		switch choice {
+		case "":
+			return attributes, false
+		case "r":
+			attributes = database.DefaultAttributes()
+			points = attributePoints
+		case "n":
+			if points == 0 {
+				return attributes, true
+			}
+
+			user.WriteLine(fmt.Sprintf("You still have %v points to spend", points))
+		default:
+			if points == 0 {
+				user.WriteLine("You have no points left, reset to spend them differently")
+				break
+			}
+
+			switch choice {
+			case "s":
+				attributes.Strength++
+			case "d":
+				attributes.Dexterity++
+			case "c":
+				attributes.Constitution++
+			case "i":
+				attributes.Intelligence++
+			}
+
+			points--
+		}
+	}
+}
+
+// chooseStartingZone has the user pick one of the zones that are flagged as
+// starting areas. The zone is nil if there aren't any, and the result is false
+// if the user gives up.
+func chooseStartingZone(conn *wrappedConnection, user *database.User) (*database.Zone, bool) {
+	zones := model.GetStartingZones()
+
+	if len(zones) == 0 {
+		return nil, true
+	}
+
+	if len(zones) == 1 {
+		return zones[0], true
+	}
+
+	menu := utils.NewMenu("Starting area")
+
+	for i, zone := range zones {
+		menu.AddActionData(i+1, zone.GetName(), zone.GetId())
+	}
+
+	choice, zoneId := menu.Exec(conn, user.GetColorMode())
+
+	if choice == "" {
+		return nil, false
+	}
+
+	return model.GetZone(zoneId), true
+}
+
+// vim: nocindent
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cristofori/kmud/utils"
+	"net"
+	"time"
+)
+
+// How often connections are checked for being idle
+const idleCheckInterval = 5 * time.Second
+
+// listen opens a TCP listener with keepalive probes at the configured
+// interval, so that connections to clients that vanished get cleaned up
+func (self *Server) listen(addr string) (net.Listener, error) {
+	var lc net.ListenConfig
+
+	lc.KeepAlive = self.config.KeepAlive
+	if lc.KeepAlive == 0 {
+		lc.KeepAlive = -1
+	}
+
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// idleTimeout returns how long the connection may go without input, zero for
+// no limit
+func (self *Server) idleTimeout(conn *wrappedConnection) time.Duration {
+	if conn.playing() {
+		return self.config.IdleTimeout
+	}
+
+	return self.config.MenuIdleTimeout
+}
+
+// watch disconnects the connection once it's been idle for too long, warning
+// the user beforehand, and pings the client at the keepalive interval so that
+// a dead connection is noticed. Returns once stop is closed.
+func (self *Server) watch(conn *wrappedConnection, stop chan bool) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	warned := false
+	lastPing := time.Now()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		idle := conn.idle()
+		timeout := self.idleTimeout(conn)
+		warning := self.config.IdleWarning
+
+		if timeout > 0 && idle >= timeout {
+			fmt.Println("Disconnecting idle client:", conn.RemoteAddr())
+			utils.WriteLine(conn, "\r\nYou've been disconnected for being idle", utils.ColorModeNone)
+			conn.Close()
+			return
+		}
+
+		if timeout > 0 && warning > 0 && idle >= timeout-warning {
+			if !warned {
+				utils.WriteLine(conn, fmt.Sprintf("\r\nYou've been idle for a while, you'll be disconnected in %v unless you do something",
+					formatWait(timeout-idle)), utils.ColorModeNone)
+				warned = true
+			}
+		} else {
+			warned = false
+		}
+
+		if self.config.KeepAlive > 0 && time.Since(lastPing) >= self.config.KeepAlive {
+			lastPing = time.Now()
+
+			// Pinging an SSH client waits for its reply
+			go func() {
+				if err := conn.ping(); err != nil {
+					conn.Close()
+				}
+			}()
+		}
+	}
+}
+
+// vim: nocindent
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Cristofori/kmud/database"
+	"github.com/Cristofori/kmud/model"
+	"github.com/Cristofori/kmud/utils"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often sanctions that have run out are cleaned up, and jailed characters
+// whose time is up are released
+const moderationInterval = time.Minute
+
+// addressBanned tells the client and returns true if it's connecting from a
+// banned address range
+func addressBanned(conn *wrappedConnection) bool {
+	if ban := model.GetBanFor(net.ParseIP(remoteHost(conn))); ban != nil {
+		fmt.Println("Refusing banned address:", conn.RemoteAddr())
+		utils.WriteLine(conn, "Your address has been banned "+ban.GetSanction().String(), utils.ColorModeNone)
+		return true
+	}
+
+	return false
+}
+
+// banned tells the user and returns true if they're banned, either their
+// account or the address they're connecting from
+func banned(conn *wrappedConnection, user *database.User) bool {
+	if sanction, banned := user.GetSanction(database.SanctionBan); banned {
+		fmt.Println("Refusing banned user:", user.GetName(), conn.RemoteAddr())
+		utils.WriteLine(conn, "You've been banned "+sanction.String(), utils.ColorModeNone)
+		return true
+	}
+
+	return addressBanned(conn)
+}
+
+// moderate releases jailed characters once their time is up, and removes
+// address bans and failed logins that have run out, until the server is done
+func (self *Server) moderate() {
+	ticker := time.NewTicker(moderationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-self.doneChannel():
+			return
+		case <-ticker.C:
+		}
+
+		for _, pc := range model.GetOnlinePlayerCharacters() {
+			model.EnforceJail(pc)
+		}
+
+		model.PurgeExpiredBans()
+
+		// Failed logins from addresses and names that never came back
+		self.accountLimiter.Sweep()
+		self.addressLimiter.Sweep()
+		self.newUserLimiter.Sweep()
+	}
+}
+
+// askSanction asks an admin how long a sanction lasts and why. Returns false
+// if they give up.
+func askSanction(conn *wrappedConnection, admin *database.User) (database.Sanction, bool) {
+	for {
+		input := admin.GetInput("Duration (e.g. 30m, 12h, 7d or forever): ")
+
+		if input == "" {
+			return database.Sanction{}, false
+		}
+
+		duration, err := utils.ParseDuration(input)
+
+		if err != nil {
+			admin.WriteLine(err.Error())
+			continue
+		}
+
+		reason := strings.TrimSpace(utils.GetRawUserInput(conn, "Reason: ", admin.GetColorMode()))
+		return database.NewSanction(duration, reason, admin.GetName()), true
+	}
+}
+
+// toggleSanction lifts the user's sanction of the given kind if they're under
+// one, and otherwise asks the admin for the details of a new one
+func toggleSanction(conn *wrappedConnection, admin *database.User, user *database.User, kind database.SanctionKind) {
+	if user == admin {
+		admin.WriteLine(fmt.Sprintf("You can't %s yourself", kind))
+		return
+	}
+
+	if _, active := user.GetSanction(kind); active {
+		model.Lift(user, kind)
+		admin.WriteLine(fmt.Sprintf("%s: %s lifted", user.GetName(), kind))
+		fmt.Printf("Sanction %s on %s lifted by %s\n", kind, user.GetName(), admin.GetName())
+		return
+	}
+
+	sanction, ok := askSanction(conn, admin)
+
+	if !ok {
+		return
+	}
+
+	if err := model.Impose(user, kind, sanction); err != nil {
+		admin.WriteLine(err.Error())
+		return
+	}
+
+	admin.WriteLine(fmt.Sprintf("%s: %s %s", user.GetName(), kind, sanction))
+	fmt.Printf("Sanction %s on %s %s\n", kind, user.GetName(), sanction)
+}
+
+// sanctionActions adds the actions that put on or lift each kind of sanction
+// to the user's admin menu
+func sanctionActions(menu *utils.Menu, user *database.User) {
+	actions := []struct {
+		key    string
+		kind   database.SanctionKind
+		impose string
+		lift   string
+	}{
+		{"b", database.SanctionBan, "Ban", "Unban"},
+		{"m", database.SanctionMute, "Mute", "Unmute"},
+		{"j", database.SanctionJail, "Jail", "Release from jail"},
+	}
+
+	for _, action := range actions {
+		if sanction, active := user.GetSanction(action.kind); active {
+			menu.AddAction(action.key, fmt.Sprintf("%s (%s)", action.lift, sanction))
+		} else {
+			menu.AddAction(action.key, action.impose)
+		}
+	}
+}
+
+func banMenu(bans []*database.Ban) *utils.Menu {
+	menu := utils.NewMenu("Address bans")
+	menu.AddAction("a", "Add")
+
+	for i, ban := range bans {
+		menu.AddActionData(i+1, fmt.Sprintf("%s %s", ban.GetRange(), ban.GetSanction()), ban.GetId())
+	}
+
+	return menu
+}
+
+// manageBans lets an admin add address range bans, and lift them by picking
+// them from the list
+func manageBans(conn *wrappedConnection, admin *database.User) {
+	for {
+		bans := model.GetBans()
+		choice, _ := banMenu(bans).Exec(conn, admin.GetColorMode())
+
+		if choice == "" {
+			return
+		}
+
+		if choice == "a" {
+			input := admin.GetInput("Address or range (e.g. 192.0.2.0/24): ")
+
+			if input == "" {
+				continue
+			}
+
+			network, err := utils.ParseAddressRange(input)
+
+			if err != nil {
+				admin.WriteLine(err.Error())
+				continue
+			}
+
+			sanction, ok := askSanction(conn, admin)
+
+			if ok {
+				model.BanAddresses(network, sanction)
+				fmt.Printf("Sanction ban on %s %s\n", network, sanction)
+			}
+
+			continue
+		}
+
+		i, err := strconv.Atoi(choice)
+
+		if err == nil && i >= 1 && i <= len(bans) {
+			ban := bans[i-1]
+			model.DeleteObject(ban)
+			admin.WriteLine(ban.GetRange() + ": ban lifted")
+			fmt.Printf("Sanction ban on %s lifted by %s\n", ban.GetRange(), admin.GetName())
+		}
+	}
+}
+
+// vim: nocindent
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Cristofori/kmud/database"
+	"github.com/Cristofori/kmud/model"
+	"strings"
+	"time"
+)
+
+// How often deleted users and characters are checked for being past the
+// retention period
+const sweepInterval = time.Hour
+
+// confirmDelete has the user type the name of what's about to be deleted, so
+// that picking the wrong menu entry doesn't delete anything
+func confirmDelete(user *database.User, name string) bool {
+	answer := user.GetInput(fmt.Sprintf("Type %s to confirm deleting it: ", name))
+
+	if !strings.EqualFold(answer, name) {
+		user.WriteLine("Nothing was deleted")
+		return false
+	}
+
+	return true
+}
+
+// sweep purges deleted users and characters once they've been deleted for
+// longer than the retention period, until the server is done. Does nothing if
+// they're kept forever.
+func (self *Server) sweep() {
+	retention := self.config.DeleteRetention
+
+	if retention == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		if purged := model.PurgeDeleted(time.Now().Add(-retention)); purged > 0 {
+			fmt.Printf("Purged %v deleted users and characters\n", purged)
+		}
+
+		select {
+		case <-self.doneChannel():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// vim: nocindent
@@ -1,28 +1,79 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
+	"github.com/Cristofori/kmud/audit"
+	"github.com/Cristofori/kmud/config"
 	"gopkg.in/mgo.v2"
 	"github.com/Cristofori/kmud/database"
 	"github.com/Cristofori/kmud/engine"
+	"github.com/Cristofori/kmud/limiter"
 	"github.com/Cristofori/kmud/model"
+	"github.com/Cristofori/kmud/recorder"
 	"github.com/Cristofori/kmud/session"
 	"github.com/Cristofori/kmud/telnet"
 	"github.com/Cristofori/kmud/utils"
+	"io"
 	"net"
 	"runtime/debug"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 type Server struct {
-	listener net.Listener
+	config    *config.Config
+	listener  net.Listener
+	startTime time.Time
+	msspInfo  map[string]string
+	recordDir string
+	webAddr   string
+
+	sshAddr        string
+	sshHostKeyFile string
+
+	tlsAddr     string
+	tlsCertFile string
+	tlsKeyFile  string
+	secureAdmin bool
+
+	accountLimiter *limiter.Limiter
+	addressLimiter *limiter.Limiter
+	newUserLimiter *limiter.Limiter
+
+	mutex        sync.Mutex
+	listeners    []io.Closer
+	connections  map[*wrappedConnection]bool
+	handshakes   map[net.Conn]bool
+	started      bool
+	shuttingDown bool
+	hurry        chan bool
+	done         chan bool
 }
 
 type wrappedConnection struct {
-	telnet  *telnet.Telnet
+	conn    net.Conn
+	telnet  *telnet.Telnet // nil for SSH connections
+	ssh     *sshChannel    // nil for telnet connections
 	watcher *utils.WatchableReadWriter
+	editor  *utils.LineEditor
+
+	// Set while a password is being entered on an SSH connection, telnet
+	// connections negotiate echo instead
+	inputHidden bool
+
+	// True for connections that are encrypted (TLS or SSH)
+	secure bool
+
+	// Called once the session on this connection is done with it
+	onRelease func()
+
+	stateMutex sync.Mutex
+	lastInput  time.Time
+	inGame     bool
 }
 
 func (s *wrappedConnection) Write(p []byte) (int, error) {
@@ -30,35 +81,188 @@ func (s *wrappedConnection) Write(p []byte) (int, error) {
 }
 
 func (s *wrappedConnection) Read(p []byte) (int, error) {
-	return s.watcher.Read(p)
+	n, err := s.watcher.Read(p)
+
+	if n > 0 {
+		s.stateMutex.Lock()
+		s.lastInput = time.Now()
+		s.stateMutex.Unlock()
+	}
+
+	return n, err
+}
+
+// idle returns how long it's been since the client sent anything
+func (s *wrappedConnection) idle() time.Duration {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	return time.Since(s.lastInput)
+}
+
+func (s *wrappedConnection) setPlaying(playing bool) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	s.inGame = playing
+}
+
+// playing returns true while the connection is in a game session, rather than
+// in the menus
+func (s *wrappedConnection) playing() bool {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	return s.inGame
+}
+
+// ping checks that the client is still there
+func (s *wrappedConnection) ping() error {
+	if s.telnet != nil {
+		return s.telnet.Ping()
+	} else if s.ssh != nil {
+		return s.ssh.Ping()
+	}
+
+	return nil
 }
 
 func (s *wrappedConnection) Close() error {
-	return s.telnet.Close()
+	return s.conn.Close()
 }
 
 func (s *wrappedConnection) LocalAddr() net.Addr {
-	return s.telnet.LocalAddr()
+	return s.conn.LocalAddr()
 }
 
 func (s *wrappedConnection) RemoteAddr() net.Addr {
-	return s.telnet.RemoteAddr()
+	return s.conn.RemoteAddr()
 }
 
 func (s *wrappedConnection) SetDeadline(dl time.Time) error {
-	return s.telnet.SetDeadline(dl)
+	return s.conn.SetDeadline(dl)
 }
 
 func (s *wrappedConnection) SetReadDeadline(dl time.Time) error {
-	return s.telnet.SetReadDeadline(dl)
+	return s.conn.SetReadDeadline(dl)
 }
 
 func (s *wrappedConnection) SetWriteDeadline(dl time.Time) error {
-	return s.telnet.SetWriteDeadline(dl)
+	return s.conn.SetWriteDeadline(dl)
+}
+
+// Secure returns true if the connection is encrypted
+func (s *wrappedConnection) Secure() bool {
+	return s.secure
+}
+
+func (s *wrappedConnection) Enabled(option telnet.TelnetCode) bool {
+	return s.telnet != nil && s.telnet.Enabled(option)
+}
+
+// NegotiatesOptions returns false for SSH connections, which have no telnet
+// options to negotiate
+func (s *wrappedConnection) NegotiatesOptions() bool {
+	return s.telnet != nil
+}
+
+// Released is called by the session when it's done with the connection,
+// because it dropped while the character stays in the game or because another
+// connection took the session over
+func (s *wrappedConnection) Released() {
+	if s.onRelease != nil {
+		s.onRelease()
+	}
+}
+
+// hideInput stops the client's input from being echoed, while a password is
+// being entered
+func (s *wrappedConnection) hideInput(hide bool) {
+	if s.telnet == nil {
+		s.inputHidden = hide
+	} else if hide {
+		s.telnet.WillEcho()
+	} else {
+		s.telnet.WontEcho()
+	}
+}
+
+// SetCharMode switches the client into or out of character mode, in which
+// input is read through a line editor. SSH connections are always in
+// character mode.
+func (s *wrappedConnection) SetCharMode(enabled bool) {
+	if s.telnet == nil {
+		return
+	}
+
+	s.telnet.SetCharMode(enabled)
+
+	if enabled {
+		s.editor = utils.NewLineEditor(func() bool {
+			return s.telnet.LocalEnabled(telnet.ECHO)
+		})
+	} else {
+		s.editor = nil
+	}
+}
+
+func (s *wrappedConnection) LineEditor() *utils.LineEditor {
+	return s.editor
+}
+
+func (s *wrappedConnection) MXPEnabled() bool {
+	return s.telnet != nil && s.telnet.MXPEnabled()
+}
+
+func (s *wrappedConnection) MarkPrompt() {
+	if s.telnet != nil {
+		s.telnet.MarkPrompt()
+	}
+}
+
+func (s *wrappedConnection) SendGMCP(module string, data interface{}) error {
+	if s.telnet == nil {
+		return nil
+	}
+
+	return s.telnet.SendGMCP(module, data)
+}
+
+// capabilities converts the terminal types reported by a client into the set
+// of features it supports
+func capabilities(types []string) database.Capabilities {
+	mtts := telnet.DetectMTTS(types)
+
+	var caps database.Capabilities
+
+	if len(types) > 0 {
+		caps.ClientName = types[0]
+	}
+
+	caps.ANSI = mtts.Has(telnet.MttsANSI)
+	caps.VT100 = mtts.Has(telnet.MttsVT100)
+	caps.UTF8 = mtts.Has(telnet.MttsUTF8)
+	caps.Colors256 = mtts.Has(telnet.Mtts256Colors)
+	caps.TrueColor = mtts.Has(telnet.MttsTrueColor)
+	caps.MouseTracking = mtts.Has(telnet.MttsMouseTracking)
+	caps.OSCColorPalette = mtts.Has(telnet.MttsOSCColorPalette)
+	caps.ScreenReader = mtts.Has(telnet.MttsScreenReader)
+	caps.Proxy = mtts.Has(telnet.MttsProxy)
+	caps.MNES = mtts.Has(telnet.MttsMNES)
+	caps.MSLP = mtts.Has(telnet.MttsMSLP)
+	caps.SSL = mtts.Has(telnet.MttsSSL)
+
+	return caps
 }
 
-func login(conn *wrappedConnection) *database.User {
+// login asks for a username and password. Failed logins are throttled per
+// account and per address. In dev mode any password is accepted.
+func (self *Server) login(conn *wrappedConnection) *database.User {
 	for {
+		if self.addressLocked(conn) {
+			return nil
+		}
+
 		username := utils.GetUserInput(conn, "Username: ", utils.ColorModeNone)
 
 		if username == "" {
@@ -68,105 +272,93 @@ func login(conn *wrappedConnection) *database.User {
 		user := model.GetUserByName(username)
 
 		if user == nil {
+			// Guessing at usernames counts against the address
+			time.Sleep(self.loginFailed(conn, ""))
 			utils.WriteLine(conn, "User not found", utils.ColorModeNone)
-		} else if user.Online() {
+		} else if user.Online() && session.ForUser(user) == nil {
 			utils.WriteLine(conn, "That user is already online", utils.ColorModeNone)
+		} else if wait := self.accountLimiter.Locked(accountKey(user.GetName())); wait > 0 {
+			utils.WriteLine(conn, "Too many failed logins for that user, try again in "+formatWait(wait), utils.ColorModeNone)
 		} else {
 			attempts := 1
-			conn.telnet.WillEcho()
+			conn.hideInput(true)
 			for {
 				password := utils.GetRawUserInputSuffix(conn, "Password: ", "\r\n", utils.ColorModeNone)
 
-				// TODO - Disabling password verification to make development easier
-				if user.VerifyPassword(password) || true {
+				if user.VerifyPassword(password) || self.config.DevMode {
+					self.accountLimiter.Reset(accountKey(user.GetName()))
 					break
 				}
 
-				if attempts >= 3 {
+				wait := self.loginFailed(conn, user.GetName())
+
+				if attempts >= 3 || self.accountLimiter.Locked(accountKey(user.GetName())) > 0 ||
+					self.addressLimiter.Locked(remoteHost(conn)) > 0 {
+					fmt.Println("Booted user due to too many failed logins (" + user.GetName() + ")")
 					utils.WriteLine(conn, "Too many failed login attempts", utils.ColorModeNone)
+					conn.hideInput(false)
 					conn.Close()
-					panic("Booted user due to too many failed logins (" + user.GetName() + ")")
+					return nil
 				}
 
 				attempts++
 
-				time.Sleep(2 * time.Second)
+				time.Sleep(wait)
 				utils.WriteLine(conn, "Invalid password", utils.ColorModeNone)
 			}
-			conn.telnet.WontEcho()
+			conn.hideInput(false)
 
 			return user
 		}
 	}
 }
 
-func newUser(conn *wrappedConnection) *database.User {
+// newUser asks for the name and password of a new account. Addresses that are
+// locked out of logging in, or that have created too many accounts lately,
+// can't create any.
+func (self *Server) newUser(conn *wrappedConnection) *database.User {
 	for {
+		if self.addressLocked(conn) {
+			return nil
+		}
+
+		if wait := self.newUserLimiter.Locked(remoteHost(conn)); wait > 0 {
+			utils.WriteLine(conn, "Too many new users from your address, try again in "+formatWait(wait), utils.ColorModeNone)
+			return nil
+		}
+
 		name := utils.GetUserInput(conn, "Desired username: ", utils.ColorModeNone)
 
 		if name == "" {
 			return nil
 		}
 
-		user := model.GetUserByName(name)
-		password := ""
-
-		if user != nil {
+		if model.UserNameTaken(name) {
 			utils.WriteLine(conn, "That name is unavailable", utils.ColorModeNone)
 		} else if err := utils.ValidateName(name); err != nil {
 			utils.WriteLine(conn, err.Error(), utils.ColorModeNone)
 		} else {
-			conn.telnet.WillEcho()
-			for {
-				pass1 := utils.GetRawUserInputSuffix(conn, "Desired password: ", "\r\n", utils.ColorModeNone)
-
-				if len(pass1) < 7 {
-					utils.WriteLine(conn, "Passwords must be at least 7 letters in length", utils.ColorModeNone)
-					continue
-				}
+			password := self.askNewPassword(conn, "Desired password: ")
 
-				pass2 := utils.GetRawUserInputSuffix(conn, "Confirm password: ", "\r\n", utils.ColorModeNone)
+			if password == "" {
+				continue
+			}
 
-				if pass1 != pass2 {
-					utils.WriteLine(conn, "Passwords do not match", utils.ColorModeNone)
-					continue
-				}
+			// The first user runs the place
+			firstUser := len(model.GetUsers()) == 0
 
-				password = pass1
+			user := model.CreateUser(name, password)
+			self.newUserLimiter.Fail(remoteHost(conn))
 
-				break
+			if firstUser {
+				user.GrantRole(database.RoleAdmin)
+				utils.WriteLine(conn, "You're the first user, and have been made an admin", utils.ColorModeNone)
 			}
-			conn.telnet.WontEcho()
-
-			user = model.CreateUser(name, password)
 			return user
 		}
 	}
 }
 
-func newPlayer(conn *wrappedConnection, user *database.User) *database.PlayerChar {
-	// TODO: character slot limit
-	const SizeLimit = 12
-	for {
-		name := user.GetInput("Desired character name: ")
-
-		if name == "" {
-			return nil
-		}
-
-		char := model.GetCharacterByName(name)
-
-		if char != nil {
-			user.WriteLine("That name is unavailable")
-		} else if err := utils.ValidateName(name); err != nil {
-			user.WriteLine(err.Error())
-		} else {
-			room := model.GetRooms()[0] // TODO: Better way to pick an initial character location
-			return model.CreatePlayerCharacter(name, user, room)
-		}
-	}
-}
-
 func mainMenu() *utils.Menu {
 	menu := utils.NewMenu("MUD")
 
@@ -182,11 +374,14 @@ func userMenu(user *database.User) *utils.Menu {
 
 	menu := utils.NewMenu(user.GetName())
 	menu.AddAction("l", "Logout")
-	menu.AddAction("a", "Admin")
+	if user.HasPermission(database.PermissionAdmin) {
+		menu.AddAction("a", "Admin")
+	}
 	menu.AddAction("n", "New character")
 	if len(chars) > 0 {
 		menu.AddAction("d", "Delete character")
 	}
+	menu.AddAction("p", "Change password")
 
 	// TODO: Sort character list
 
@@ -218,6 +413,8 @@ func deleteMenu(user *database.User) *utils.Menu {
 func adminMenu() *utils.Menu {
 	menu := utils.NewMenu("Admin")
 	menu.AddAction("u", "Users")
+	menu.AddAction("l", "Login lockouts")
+	menu.AddAction("b", "Address bans")
 	return menu
 }
 
@@ -249,8 +446,15 @@ func userSpecificMenu(user *database.User) *utils.Menu {
 		suffix = "(Offline)"
 	}
 
-	menu := utils.NewMenu("User: " + user.GetName() + " " + suffix)
+	var roles []string
+	for _, role := range user.GetRoles() {
+		roles = append(roles, string(role))
+	}
+
+	menu := utils.NewMenu("User: " + user.GetName() + " " + suffix + " [" + strings.Join(roles, ", ") + "]")
 	menu.AddAction("d", "Delete")
+	menu.AddAction("p", "Reset password")
+	sanctionActions(menu, user)
 
 	if user.Online() {
 		menu.AddAction("w", "Watch")
@@ -259,14 +463,209 @@ func userSpecificMenu(user *database.User) *utils.Menu {
 	return menu
 }
 
-func handleConnection(conn *wrappedConnection) {
+// Configure applies the given configuration to the server, and to the packages
+// that it runs
+func (self *Server) Configure(cfg *config.Config) {
+	self.config = cfg
+
+	self.SetRecordDir(cfg.RecordDir)
+	self.SetWebAddr(cfg.WebAddr)
+	self.SetSSHAddr(cfg.SSHAddr, cfg.SSHHostKey)
+	self.SetTLS(cfg.TLSAddr, cfg.TLSCert, cfg.TLSKey)
+	self.SetSecureAdmin(cfg.SecureAdmin)
+	self.setLimits(cfg)
+
+	utils.SetNameLength(cfg.MinNameLength, cfg.MaxNameLength)
+
+	if cfg.UnicodeNames {
+		utils.SetNamePolicy(utils.NamePolicyUnicode)
+	} else {
+		utils.SetNamePolicy(utils.NamePolicyASCII)
+	}
+
+	session.SetInputInterval(cfg.InputInterval)
+	session.SetRegenAmount(cfg.RegenAmount)
+	session.SetLinkDeadTimeout(cfg.LinkDeadTimeout)
+	model.SetCombatInterval(cfg.CombatInterval)
+	engine.SetRoamInterval(cfg.RoamInterval)
+}
+
+// SetMSSPInfo sets one of the static variables (e.g. CONTACT, WEBSITE) that are
+// reported to MUD crawlers through MSSP
+func (self *Server) SetMSSPInfo(name string, value string) {
+	if self.msspInfo == nil {
+		self.msspInfo = map[string]string{}
+	}
+
+	self.msspInfo[name] = value
+}
+
+// SetRecordDir turns on recording of every connection's raw byte stream, the
+// recordings are saved to the given directory. See the recorder package.
+func (self *Server) SetRecordDir(dir string) {
+	self.recordDir = dir
+}
+
+// SetWebAddr turns on the web client, which is served over HTTP on the given
+// address (e.g. ":8080")
+func (self *Server) SetWebAddr(addr string) {
+	self.webAddr = addr
+}
+
+// port returns the port of a listener's address, as reported through MSSP.
+// It's "0" when the listener isn't running.
+func port(addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+
+	if err != nil || addr == "" {
+		return "0"
+	}
+
+	return port
+}
+
+// msspStatus returns the static MSSP variables combined with the live status
+// of the server
+func (self *Server) msspStatus() map[string]string {
+	status := map[string]string{
+		"NAME":     "kmud",
+		"CODEBASE": "kmud",
+		"FAMILY":   "Custom",
+		"LANGUAGE": "English",
+		"ANSI":     "1",
+		"MCCP":     "1",
+		"GMCP":     "1",
+		"MXP":      "1",
+		"UTF-8":    "1",
+	}
+
+	for name, value := range self.msspInfo {
+		status[name] = value
+	}
+
+	status["PORT"] = port(self.config.Addr)
+	status["SSL"] = port(self.tlsAddr)
+	status["PLAYERS"] = strconv.Itoa(len(model.GetOnlinePlayerCharacters()))
+	status["UPTIME"] = strconv.FormatInt(self.startTime.Unix(), 10)
+	status["AREAS"] = strconv.Itoa(len(model.GetZones()))
+	status["ROOMS"] = strconv.Itoa(len(model.GetRooms()))
+	status["MOBILES"] = strconv.Itoa(len(model.GetNpcs()))
+
+	return status
+}
+
+// loggedIn marks the user as online on this connection, and finds out about
+// the client's window size and terminal type
+func loggedIn(conn *wrappedConnection, user *database.User) {
+	user.SetOnline(true)
+	user.SetConnection(conn)
+
+	if conn.telnet != nil {
+		conn.telnet.DoWindowSize()
+		conn.telnet.DoTerminalType()
+	} else {
+		user.SetWindowSize(conn.ssh.WindowSize())
+
+		tt := conn.ssh.TerminalType()
+		user.SetTerminalType(tt)
+		user.SetCapabilities(capabilities([]string{tt}))
+	}
+}
+
+// grantAdmin makes the user named in the configuration an admin, and warns if
+// there are no admins at all
+func (self *Server) grantAdmin() {
+	if self.config.Admin != "" {
+		user := model.GetUserByName(self.config.Admin)
+
+		if user == nil {
+			fmt.Println("WARNING: Can't make", self.config.Admin, "an admin, there's no such user")
+		} else {
+			user.GrantRole(database.RoleAdmin)
+			fmt.Println(user.GetName(), "is an admin")
+		}
+	}
+
+	users := model.GetUsers()
+
+	for _, user := range users {
+		if user.HasRole(database.RoleAdmin) {
+			return
+		}
+	}
+
+	if len(users) > 0 {
+		fmt.Println("WARNING: There are no admins, start the server with -admin <user> to make one")
+	}
+}
+
+// resume moves the user's session to this connection if they're still in the
+// game (e.g. their character went link-dead), returning once the session ends
+func resume(conn *wrappedConnection, user *database.User) {
+	s := session.ForUser(user)
+
+	if s == nil {
+		return
+	}
+
+	fmt.Println("Reattaching", user.GetName(), "to", conn.RemoteAddr())
+
+	conn.setPlaying(true)
+	if s.Reattach(conn) {
+		s.Wait()
+	}
+	conn.setPlaying(false)
+}
+
+// handleConnection runs a client through the menus and its sessions until it
+// disconnects. user is set for clients that were already authenticated by
+// the listener (SSH public keys), everyone else has to log in.
+func (self *Server) handleConnection(conn *wrappedConnection, user *database.User) {
+	if !self.addConnection(conn) {
+		fmt.Println("Connection limit reached, refusing:", conn.RemoteAddr())
+		utils.WriteLine(conn, "Too many connections, try again later", utils.ColorModeNone)
+		conn.Close()
+		return
+	}
+
+	// A session keeps running on this goroutine after its connection has
+	// dropped (link-dead) or been taken over by another one. From then on the
+	// connection no longer counts towards the limits, and isn't watched.
+	stop := make(chan bool)
+	var releaseOnce sync.Once
+
+	conn.onRelease = func() {
+		releaseOnce.Do(func() {
+			close(stop)
+			self.removeConnection(conn)
+		})
+	}
+	defer conn.Released()
+
+	if addressBanned(conn) {
+		conn.Close()
+		return
+	}
+
+	conn.lastInput = time.Now()
+	go self.watch(conn, stop)
+
 	defer conn.Close()
 
-	var user *database.User
 	var pc *database.PlayerChar
 
+	// The last session started on this connection
+	var started *session.Session
+
 	defer func() {
 		if r := recover(); r != nil {
+			// The session moved to another connection, which looks after the
+			// user from now on
+			if started != nil && started.Conn() != conn {
+				user = nil
+				pc = nil
+			}
+
 			username := ""
 			charname := ""
 
@@ -280,7 +679,10 @@ func handleConnection(conn *wrappedConnection) {
 				charname = pc.GetName()
 			}
 
-			debug.PrintStack()
+			// Every connection is cut during a shutdown, that's expected
+			if !self.stopping() {
+				debug.PrintStack()
+			}
 
 			fmt.Printf("Lost connection to client (%v/%v): %v, %v\n",
 				username,
@@ -290,6 +692,74 @@ func handleConnection(conn *wrappedConnection) {
 		}
 	}()
 
+	if conn.telnet != nil {
+		conn.telnet.Listen(func(code telnet.TelnetCode, data []byte) {
+			switch code {
+			case telnet.WS:
+				if len(data) != 4 {
+					fmt.Println("Malformed window size data:", data)
+					return
+				}
+
+				if user != nil {
+					// Each is a 16 bit number in network byte order
+					width := int(data[0])<<8 | int(data[1])
+					height := int(data[2])<<8 | int(data[3])
+					user.SetWindowSize(width, height)
+				}
+
+			case telnet.TT:
+				if user != nil {
+					types := conn.telnet.TerminalTypes()
+
+					if len(types) > 0 {
+						user.SetTerminalType(types[0])
+					}
+
+					user.SetCapabilities(capabilities(types))
+				}
+
+			case telnet.GMCP:
+				module, _ := telnet.ParseGMCP(data)
+
+				if module == "Core.Ping" {
+					conn.telnet.SendGMCP("Core.Ping", nil)
+				}
+			}
+		})
+
+		conn.telnet.WillCompress()
+		conn.telnet.WillGMCP()
+		conn.telnet.WillMSSP(self.msspStatus)
+		conn.telnet.WillCharset()
+		conn.telnet.WillEOR()
+		conn.telnet.WillMXP()
+	} else {
+		conn.ssh.Listen(func(width int, height int) {
+			if user != nil {
+				user.SetWindowSize(width, height)
+			}
+		})
+	}
+
+	if user != nil {
+		if banned(conn, user) {
+			return
+		} else if user.Online() && session.ForUser(user) == nil {
+			utils.WriteLine(conn, "That user is already online", utils.ColorModeNone)
+			user = nil
+		} else {
+			loggedIn(conn, user)
+
+			if self.forcePasswordChange(conn, user) {
+				resume(conn, user)
+			} else {
+				user.SetOnline(false)
+				user = nil
+			}
+		}
+	}
+
 	for {
 		if user == nil {
 			menu := mainMenu()
@@ -297,9 +767,9 @@ func handleConnection(conn *wrappedConnection) {
 
 			switch choice {
 			case "l":
-				user = login(conn)
+				user = self.login(conn)
 			case "n":
-				user = newUser(conn)
+				user = self.newUser(conn)
 			case "":
 				fallthrough
 			case "q":
@@ -312,30 +782,26 @@ func handleConnection(conn *wrappedConnection) {
 				continue
 			}
 
-			user.SetOnline(true)
-			user.SetConnection(conn)
-
-			conn.telnet.DoWindowSize()
-			conn.telnet.DoTerminalType()
-
-			conn.telnet.Listen(func(code telnet.TelnetCode, data []byte) {
-				switch code {
-				case telnet.WS:
-					if len(data) != 4 {
-						fmt.Println("Malformed window size data:", data)
-						return
-					}
+			if banned(conn, user) {
+				return
+			}
 
-					width := (255 * data[0]) + data[1]
-					height := (255 * data[2]) + data[3]
-					user.SetWindowSize(int(width), int(height))
+			loggedIn(conn, user)
 
-				case telnet.TT:
-					user.SetTerminalType(string(data))
-				}
-			})
+			if !self.forcePasswordChange(conn, user) {
+				user.SetOnline(false)
+				user = nil
+				continue
+			}
 
+			resume(conn, user)
 		} else if pc == nil {
+			// Bans can come in while the user is in the menus
+			if banned(conn, user) {
+				user.SetOnline(false)
+				return
+			}
+
 			menu := userMenu(user)
 			choice, charId := menu.Exec(conn, user.GetColorMode())
 
@@ -346,11 +812,39 @@ func handleConnection(conn *wrappedConnection) {
 				user.SetOnline(false)
 				user = nil
 			case "a":
+				if !user.HasPermission(database.PermissionAdmin) {
+					break
+				}
+
+				if self.secureAdmin && !conn.Secure() {
+					user.WriteLine("The admin menu is only available over an encrypted connection")
+					break
+				}
+
 				adminMenu := adminMenu()
 				for {
 					choice, _ := adminMenu.Exec(conn, user.GetColorMode())
 					if choice == "" {
 						break
+					} else if choice == "b" {
+						manageBans(conn, user)
+					} else if choice == "l" {
+						for {
+							lockouts := self.lockouts()
+							choice, _ := lockoutMenu(lockouts).Exec(conn, user.GetColorMode())
+
+							if choice == "" {
+								break
+							}
+
+							i, err := strconv.Atoi(choice)
+
+							if err == nil && i >= 1 && i <= len(lockouts) {
+								lockout := lockouts[i-1]
+								lockout.limiter.Reset(lockout.entry.Key)
+								user.WriteLine("Unlocked " + lockout.entry.Key)
+							}
+						}
 					} else if choice == "u" {
 						for {
 							userAdminMenu := userAdminMenu()
@@ -367,8 +861,23 @@ func handleConnection(conn *wrappedConnection) {
 										if choice == "" {
 											break
 										} else if choice == "d" {
-											model.DeleteUserId(userId)
-											break
+											userToDelete := model.GetUser(userId)
+
+											if userToDelete == user {
+												user.WriteLine("You can't delete yourself!")
+											} else if confirmDelete(user, userToDelete.GetName()) {
+												model.DeleteUser(userToDelete)
+												fmt.Printf("User %s deleted by %s\n", userToDelete.GetName(), user.GetName())
+												break
+											}
+										} else if choice == "p" {
+											self.resetPassword(conn, user, model.GetUser(userId))
+										} else if choice == "b" {
+											toggleSanction(conn, user, model.GetUser(userId), database.SanctionBan)
+										} else if choice == "m" {
+											toggleSanction(conn, user, model.GetUser(userId), database.SanctionMute)
+										} else if choice == "j" {
+											toggleSanction(conn, user, model.GetUser(userId), database.SanctionJail)
 										} else if choice == "w" {
 											userToWatch := model.GetUser(userId)
 
@@ -389,7 +898,9 @@ func handleConnection(conn *wrappedConnection) {
 					}
 				}
 			case "n":
-				pc = newPlayer(conn, user)
+				pc = self.newPlayer(conn, user)
+			case "p":
+				self.changePassword(conn, user)
 			case "d":
 				for {
 					deleteMenu := deleteMenu(user)
@@ -402,8 +913,12 @@ func handleConnection(conn *wrappedConnection) {
 					_, err := strconv.Atoi(deleteChoice)
 
 					if err == nil {
-						// TODO: Delete confirmation
-						model.DeletePlayerCharacterId(deleteCharId)
+						pc := model.GetPlayerCharacter(deleteCharId)
+
+						if confirmDelete(user, pc.GetName()) {
+							model.DeletePlayerCharacter(pc)
+							user.WriteLine(pc.GetName() + " has been deleted")
+						}
 					}
 				}
 
@@ -415,25 +930,45 @@ func handleConnection(conn *wrappedConnection) {
 				}
 			}
 		} else {
-			session := session.NewSession(conn, user, pc)
-			session.Exec()
+			if banned(conn, user) {
+				user.SetOnline(false)
+				return
+			}
+
+			model.EnforceJail(pc)
+			started = session.NewSession(conn, user, pc)
+			conn.setPlaying(true)
+			started.Exec()
+			conn.setPlaying(false)
+
+			if started.Conn() != conn {
+				return
+			}
+
 			pc = nil
 		}
 	}
 }
 
 func (self *Server) Start() {
+	self.startTime = time.Now()
+
+	if self.config.AuditLog != "" {
+		utils.HandleError(audit.Open(self.config.AuditLog))
+	}
+
 	fmt.Printf("Connecting to database... ")
-	session, err := mgo.Dial("localhost")
+	session, err := mgo.Dial(self.config.DatabaseHost)
 
 	utils.HandleError(err)
 
 	fmt.Println("done.")
 
-	self.listener, err = net.Listen("tcp", ":8945")
+	self.listener, err = self.listen(self.config.Addr)
 	utils.HandleError(err)
+	self.addListener(self.listener)
 
-	err = model.Init(database.NewMongoSession(session.Copy()), "mud")
+	err = model.Init(database.NewMongoSession(session.Copy()), self.config.DatabaseName)
 
 	// If there are no rooms at all create one
 	rooms := model.GetRooms()
@@ -451,27 +986,88 @@ func (self *Server) Start() {
 		model.CreateRoom(zone, database.Coordinate{X: 0, Y: 0, Z: 0})
 	}
 
-	fmt.Println("Server listening on port 8945")
+	self.grantAdmin()
+	go self.sweep()
+	go self.moderate()
+
+	self.mutex.Lock()
+	self.started = true
+	self.mutex.Unlock()
+
+	fmt.Println("Server listening on", self.config.Addr)
+
+	if self.config.DevMode {
+		fmt.Println("WARNING: Dev mode is on, passwords aren't being checked")
+	}
 }
 
 func (self *Server) Listen() {
 	for {
 		conn, err := self.listener.Accept()
+
+		if err != nil && self.stopping() {
+			return
+		}
+
 		utils.HandleError(err)
 		fmt.Println("Client connected:", conn.RemoteAddr())
-		t := telnet.NewTelnet(conn)
+		self.accept(conn)
+	}
+}
+
+// record starts recording the connection's byte stream, if recording is on
+func (self *Server) record(conn net.Conn) net.Conn {
+	if self.recordDir == "" {
+		return conn
+	}
 
-		wc := utils.NewWatchableReadWriter(t)
+	recorded, err := recorder.Start(conn, self.recordDir)
 
-		go handleConnection(&wrappedConnection{t, wc})
+	if err != nil {
+		fmt.Println("Unable to record connection:", err)
+		return conn
 	}
+
+	return recorded
+}
+
+// accept starts a session on a newly connected telnet client, whichever
+// listener it arrived through
+func (self *Server) accept(conn net.Conn) {
+	_, secure := conn.(*tls.Conn)
+
+	t := telnet.NewTelnet(self.record(conn))
+
+	wc := utils.NewWatchableReadWriter(t)
+
+	go self.handleConnection(&wrappedConnection{conn: t, telnet: t, watcher: wc, secure: secure}, nil)
 }
 
 func (self *Server) Exec() {
+	if self.config == nil {
+		self.Configure(config.Default())
+	}
+
 	database.GetTime()
 	self.Start()
 	engine.Start()
+
+	if self.webAddr != "" {
+		go self.listenWeb()
+	}
+
+	if self.sshAddr != "" {
+		go self.listenSSH()
+	}
+
+	if self.tlsAddr != "" {
+		go self.listenTLS()
+	}
+
 	self.Listen()
+
+	// Listen only returns once a shutdown has started
+	<-self.doneChannel()
 }
 
 // vim: nocindent
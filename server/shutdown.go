@@ -0,0 +1,267 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Cristofori/kmud/database"
+	"github.com/Cristofori/kmud/model"
+	"github.com/Cristofori/kmud/utils"
+	"io"
+	"net"
+	"time"
+)
+
+// How long to wait for sessions to end after their connections are closed
+const disconnectTimeout = 5 * time.Second
+
+// Points in the shutdown countdown at which players are warned again
+var countdownWarnings = []time.Duration{
+	5 * time.Minute,
+	time.Minute,
+	30 * time.Second,
+	10 * time.Second,
+	5 * time.Second,
+}
+
+// addListener registers a listener to be closed when the server shuts down
+func (self *Server) addListener(listener io.Closer) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.listeners = append(self.listeners, listener)
+}
+
+// addConnection registers a connection to be closed when the server shuts
+// down. Returns false if the connection would go over the configured limits,
+// overall or for its address.
+func (self *Server) addConnection(conn *wrappedConnection) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.connections == nil {
+		self.connections = map[*wrappedConnection]bool{}
+	}
+
+	if !self.withinLimits(remoteHost(conn)) {
+		return false
+	}
+
+	self.connections[conn] = true
+	return true
+}
+
+// addHandshake counts a connection that hasn't got as far as a session yet
+// (an SSH client that hasn't asked for a shell, or a browser that hasn't
+// upgraded to a WebSocket) towards the connection limits. It returns false if
+// the connection would go over them.
+func (self *Server) addHandshake(conn net.Conn) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.handshakes == nil {
+		self.handshakes = map[net.Conn]bool{}
+	}
+
+	if !self.withinLimits(remoteHost(conn)) {
+		return false
+	}
+
+	self.handshakes[conn] = true
+	return true
+}
+
+func (self *Server) removeHandshake(conn net.Conn) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	delete(self.handshakes, conn)
+}
+
+// withinLimits returns true if one more connection from the given address
+// stays within the configured limits. The caller must hold the mutex.
+func (self *Server) withinLimits(addr string) bool {
+	if self.config == nil {
+		return true
+	}
+
+	if self.config.MaxConnections > 0 && len(self.connections)+len(self.handshakes) >= self.config.MaxConnections {
+		return false
+	}
+
+	if self.config.MaxConnectionsPerAddress > 0 {
+		count := 0
+
+		for c := range self.connections {
+			if remoteHost(c) == addr {
+				count++
+			}
+		}
+
+		for c := range self.handshakes {
+			if remoteHost(c) == addr {
+				count++
+			}
+		}
+
+		if count >= self.config.MaxConnectionsPerAddress {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (self *Server) removeConnection(conn *wrappedConnection) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	delete(self.connections, conn)
+}
+
+func (self *Server) connectionCount() int {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	return len(self.connections)
+}
+
+// stopping returns true once a shutdown has started
+func (self *Server) stopping() bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	return self.shuttingDown
+}
+
+// doneChannel returns the channel that's closed once a shutdown is complete
+func (self *Server) doneChannel() chan bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.done == nil {
+		self.done = make(chan bool)
+	}
+
+	return self.done
+}
+
+// Shutdown stops the server gracefully: no more connections are accepted,
+// players are warned with a countdown, everyone is logged out and every
+// modified object is saved. Calling it again while the countdown is running
+// cuts the countdown short.
+func (self *Server) Shutdown() {
+	done := self.doneChannel()
+
+	self.mutex.Lock()
+
+	if self.shuttingDown {
+		if self.hurry != nil {
+			close(self.hurry)
+			self.hurry = nil
+		}
+
+		self.mutex.Unlock()
+		return
+	}
+
+	self.shuttingDown = true
+	hurry := make(chan bool)
+	self.hurry = hurry
+	listeners := self.listeners
+	started := self.started
+
+	self.mutex.Unlock()
+
+	fmt.Println("Shutting down")
+
+	for _, listener := range listeners {
+		listener.Close()
+	}
+
+	// Nothing has been loaded yet, so there's nothing to save
+	if !started {
+		close(done)
+		return
+	}
+
+	countdown := 30 * time.Second
+	if self.config != nil {
+		countdown = self.config.ShutdownCountdown
+	}
+
+	self.countdown(countdown, hurry)
+	self.disconnectAll()
+
+	for _, pc := range model.GetOnlinePlayerCharacters() {
+		model.Logout(pc)
+	}
+
+	database.Flush()
+
+	fmt.Println("Shutdown complete")
+	close(done)
+}
+
+// countdown warns players of the coming shutdown, returning once the time is
+// up or the countdown is cut short
+func (self *Server) countdown(remaining time.Duration, hurry chan bool) {
+	announce := func() {
+		seconds := int((remaining + time.Second - 1) / time.Second)
+
+		unit := "seconds"
+		if seconds == 1 {
+			unit = "second"
+		}
+
+		message := fmt.Sprintf("The server is shutting down in %v %s", seconds, unit)
+		fmt.Println(message)
+		model.SystemMessage(message)
+	}
+
+	if remaining <= 0 {
+		return
+	}
+
+	announce()
+
+	for _, warning := range countdownWarnings {
+		if warning >= remaining {
+			continue
+		}
+
+		select {
+		case <-time.After(remaining - warning):
+			remaining = warning
+			announce()
+		case <-hurry:
+			return
+		}
+	}
+
+	select {
+	case <-time.After(remaining):
+	case <-hurry:
+	}
+}
+
+// disconnectAll closes every connection, and waits for their sessions to end
+func (self *Server) disconnectAll() {
+	self.mutex.Lock()
+	var conns []*wrappedConnection
+	for conn := range self.connections {
+		conns = append(conns, conn)
+	}
+	self.mutex.Unlock()
+
+	for _, conn := range conns {
+		utils.WriteLine(conn, "\r\nThe server is shutting down, take luck!", utils.ColorModeNone)
+		conn.Close()
+	}
+
+	deadline := time.Now().Add(disconnectTimeout)
+
+	for self.connectionCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// vim: nocindent
@@ -0,0 +1,376 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/Cristofori/kmud/database"
+	"github.com/Cristofori/kmud/model"
+	"github.com/Cristofori/kmud/utils"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a client gets to complete the SSH handshake, including
+// authentication, and then again to ask for a shell
+const sshHandshakeTimeout = 30 * time.Second
+
+// sshChannel adapts an SSH session channel to a net.Conn. SSH clients are
+// always in character mode (there's no line buffering or local echo on the
+// other end of a PTY) so these connections get a line editor from the start.
+type sshChannel struct {
+	ssh.Channel
+	conn *ssh.ServerConn
+
+	writeMutex sync.Mutex
+	lastByte   byte
+
+	mutex        sync.Mutex
+	width        int
+	height       int
+	terminalType string
+	listener     func(width int, height int)
+}
+
+func newSSHChannel(channel ssh.Channel, conn *ssh.ServerConn) *sshChannel {
+	var c sshChannel
+	c.Channel = channel
+	c.conn = conn
+	c.width = 80
+	c.height = 40
+	return &c
+}
+
+// Write translates bare line feeds into CR LF, as the missing PTY would have
+func (self *sshChannel) Write(p []byte) (int, error) {
+	self.writeMutex.Lock()
+	defer self.writeMutex.Unlock()
+
+	translated := make([]byte, 0, len(p)+8)
+
+	for _, b := range p {
+		if b == '\n' && self.lastByte != '\r' {
+			translated = append(translated, '\r')
+		}
+		translated = append(translated, b)
+		self.lastByte = b
+	}
+
+	if _, err := self.Channel.Write(translated); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (self *sshChannel) Close() error {
+	self.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+	self.Channel.Close()
+	return self.conn.Close()
+}
+
+func (self *sshChannel) LocalAddr() net.Addr {
+	return self.conn.LocalAddr()
+}
+
+func (self *sshChannel) RemoteAddr() net.Addr {
+	return self.conn.RemoteAddr()
+}
+
+// Ping sends a keepalive request, which fails if the connection has died
+func (self *sshChannel) Ping() error {
+	_, _, err := self.conn.SendRequest("keepalive@openssh.com", true, nil)
+	return err
+}
+
+// Deadlines aren't supported by SSH channels
+func (self *sshChannel) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (self *sshChannel) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (self *sshChannel) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// Listen registers a function to be called whenever the client's window is
+// resized
+func (self *sshChannel) Listen(listener func(width int, height int)) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.listener = listener
+}
+
+func (self *sshChannel) WindowSize() (int, int) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	return self.width, self.height
+}
+
+func (self *sshChannel) TerminalType() string {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	return self.terminalType
+}
+
+func (self *sshChannel) setWindowSize(width uint32, height uint32) {
+	self.mutex.Lock()
+	self.width = int(width)
+	self.height = int(height)
+	listener := self.listener
+	self.mutex.Unlock()
+
+	if listener != nil {
+		listener(int(width), int(height))
+	}
+}
+
+// handleRequests answers the requests made on the session channel, calling
+// start once the client asks for a shell
+func (self *sshChannel) handleRequests(requests <-chan *ssh.Request, start func()) {
+	started := false
+
+	for req := range requests {
+		ok := false
+
+		switch req.Type {
+		case "pty-req":
+			var pty struct {
+				Term    string
+				Columns uint32
+				Rows    uint32
+				Width   uint32
+				Height  uint32
+				Modes   string
+			}
+
+			if ssh.Unmarshal(req.Payload, &pty) == nil {
+				self.mutex.Lock()
+				self.terminalType = pty.Term
+				self.mutex.Unlock()
+
+				self.setWindowSize(pty.Columns, pty.Rows)
+				ok = true
+			}
+
+		case "window-change":
+			var size struct {
+				Columns uint32
+				Rows    uint32
+				Width   uint32
+				Height  uint32
+			}
+
+			if ssh.Unmarshal(req.Payload, &size) == nil {
+				self.setWindowSize(size.Columns, size.Rows)
+				ok = true
+			}
+
+		case "shell":
+			ok = !started
+		}
+
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+
+		if req.Type == "shell" && !started {
+			started = true
+			start()
+		}
+	}
+}
+
+// SetSSHAddr turns on the SSH listener at the given address (e.g. ":2222").
+// The host key is read from hostKeyFile, one is generated if it doesn't exist.
+func (self *Server) SetSSHAddr(addr string, hostKeyFile string) {
+	self.sshAddr = addr
+	self.sshHostKeyFile = hostKeyFile
+}
+
+// authorizedKey formats a public key the way it's stored in the database
+func authorizedKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// loadHostKey reads the server's SSH host key, generating and saving a new one
+// if the file doesn't exist yet
+func loadHostKey(path string) (ssh.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		data = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			return nil, err
+		}
+
+		fmt.Println("Generated a new SSH host key:", path)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return ssh.ParsePrivateKey(data)
+}
+
+func (self *Server) sshConfig() (*ssh.ServerConfig, error) {
+	var config ssh.ServerConfig
+
+	// A key registered to the user logs them straight in
+	config.PublicKeyCallback = func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		user := model.GetUserByName(meta.User())
+
+		if user != nil && user.HasPublicKey(authorizedKey(key)) {
+			return &ssh.Permissions{Extensions: map[string]string{"user": user.GetName()}}, nil
+		}
+
+		return nil, errors.New("Unknown public key for " + meta.User())
+	}
+
+	// Anyone else is let in without any questions, and logs in through the
+	// menus just like a telnet client. Clients try public keys first.
+	config.KeyboardInteractiveCallback = func(meta ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		return &ssh.Permissions{}, nil
+	}
+
+	hostKey, err := loadHostKey(self.sshHostKeyFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.AddHostKey(hostKey)
+	return &config, nil
+}
+
+func (self *Server) listenSSH() {
+	config, err := self.sshConfig()
+	utils.HandleError(err)
+
+	listener, err := self.listen(self.sshAddr)
+	utils.HandleError(err)
+	self.addListener(listener)
+
+	fmt.Println("SSH listening on", self.sshAddr)
+
+	for {
+		conn, err := listener.Accept()
+
+		if err != nil && self.stopping() {
+			return
+		}
+
+		utils.HandleError(err)
+		go self.acceptSSH(conn, config)
+	}
+}
+
+// acceptSSH completes the SSH handshake and starts a session on the first
+// session channel the client opens
+func (self *Server) acceptSSH(conn net.Conn, config *ssh.ServerConfig) {
+	// The handshake happens before there's a session to count, so it's
+	// counted on its own to keep the connection limits in force
+	if !self.addHandshake(conn) {
+		fmt.Println("SSH connection refused, too many connections:", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(sshHandshakeTimeout))
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	conn.SetDeadline(time.Time{})
+
+	if err != nil {
+		self.removeHandshake(conn)
+		fmt.Println("SSH handshake failed:", conn.RemoteAddr(), err)
+		return
+	}
+
+	// Until the client asks for a shell there's no session to count the
+	// connection, so it stays counted as a handshake, and it's dropped if the
+	// shell doesn't come in time
+	timeout := time.AfterFunc(sshHandshakeTimeout, func() {
+		fmt.Println("SSH client didn't start a shell in time:", sshConn.RemoteAddr())
+		sshConn.Close()
+	})
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			timeout.Stop()
+			self.removeHandshake(conn)
+		})
+	}
+
+	go func() {
+		sshConn.Wait()
+		release()
+	}()
+
+	fmt.Println("SSH client connected:", sshConn.RemoteAddr(), sshConn.User())
+
+	go ssh.DiscardRequests(requests)
+
+	accepted := false
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" || accepted {
+			newChannel.Reject(ssh.Prohibited, "Only a single session is allowed")
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+
+		if err != nil {
+			continue
+		}
+
+		accepted = true
+		sc := newSSHChannel(channel, sshConn)
+
+		go sc.handleRequests(channelRequests, func() {
+			var user *database.User
+
+			if name, found := sshConn.Permissions.Extensions["user"]; found {
+				user = model.GetUserByName(name)
+			}
+
+			conn := self.record(sc)
+
+			wc := &wrappedConnection{conn: conn, ssh: sc, secure: true}
+			wc.watcher = utils.NewWatchableReadWriter(conn)
+			wc.editor = utils.NewLineEditor(func() bool {
+				return !wc.inputHidden
+			})
+
+			release()
+			go self.handleConnection(wc, user)
+		})
+	}
+}
+
+// vim: nocindent
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Cristofori/kmud/config"
+	"github.com/Cristofori/kmud/limiter"
+	"github.com/Cristofori/kmud/utils"
+	"net"
+	"strings"
+	"time"
+)
+
+// setLimits creates the limiters for failed logins, per account and per
+// address, and for new accounts per address
+func (self *Server) setLimits(cfg *config.Config) {
+	self.accountLimiter = limiter.New(cfg.LoginAttempts, cfg.LoginLockout)
+	self.addressLimiter = limiter.New(cfg.AddressLoginAttempts, cfg.LoginLockout)
+	self.newUserLimiter = limiter.New(cfg.NewUsersPerAddress, cfg.LoginLockout)
+}
+
+// remoteHost returns the address a connection comes from, without the port
+func remoteHost(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
+
+func accountKey(name string) string {
+	return strings.ToLower(name)
+}
+
+// formatWait rounds a wait up to the second, for showing to users
+func formatWait(wait time.Duration) string {
+	return ((wait + time.Second - 1) / time.Second * time.Second).String()
+}
+
+// addressLocked tells the user and returns true if their address is locked
+// out because of failed logins
+func (self *Server) addressLocked(conn *wrappedConnection) bool {
+	if wait := self.addressLimiter.Locked(remoteHost(conn)); wait > 0 {
+		utils.WriteLine(conn, "Too many failed logins from your address, try again in "+formatWait(wait), utils.ColorModeNone)
+		return true
+	}
+
+	return false
+}
+
+// loginFailed records a failed login against the account and the address, and
+// returns how long to wait before the next attempt
+func (self *Server) loginFailed(conn *wrappedConnection, name string) time.Duration {
+	addr := remoteHost(conn)
+	fmt.Println("Failed login:", name, addr)
+
+	wait := self.addressLimiter.Fail(addr)
+
+	if name != "" {
+		if accountWait := self.accountLimiter.Fail(accountKey(name)); accountWait > wait {
+			wait = accountWait
+		}
+	}
+
+	return wait
+}
+
+// lockout is an entry of one of the server's limiters, as shown in the admin
+// menu
+type lockout struct {
+	kind    string
+	limiter *limiter.Limiter
+	entry   limiter.Entry
+}
+
+func (self *Server) lockouts() []lockout {
+	var lockouts []lockout
+
+	add := func(kind string, l *limiter.Limiter) {
+		for _, entry := range l.Entries() {
+			lockouts = append(lockouts, lockout{kind: kind, limiter: l, entry: entry})
+		}
+	}
+
+	add("Account", self.accountLimiter)
+	add("Address", self.addressLimiter)
+	add("New users from", self.newUserLimiter)
+
+	return lockouts
+}
+
+func lockoutMenu(lockouts []lockout) *utils.Menu {
+	menu := utils.NewMenu("Login lockouts")
+
+	for i, l := range lockouts {
+		status := ""
+		if !l.entry.LockedUntil.IsZero() {
+			status = ", locked for " + formatWait(l.entry.LockedUntil.Sub(time.Now()))
+		}
+
+		text := fmt.Sprintf("%s %s (%v%s)", l.kind, l.entry.Key, l.entry.Failures, status)
+		menu.AddActionData(i+1, text, "")
+	}
+
+	return menu
+}
+
+// vim: nocindent
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/Cristofori/kmud/utils"
+)
+
+// SetTLS turns on a TLS listener at the given address (e.g. ":8946"), next to
+// the plain telnet port. The certificate and key are PEM files.
+func (self *Server) SetTLS(addr string, certFile string, keyFile string) {
+	self.tlsAddr = addr
+	self.tlsCertFile = certFile
+	self.tlsKeyFile = keyFile
+}
+
+// SetSecureAdmin restricts the admin menu to encrypted (TLS or SSH)
+// connections, so that admins can't be made to log in over plaintext
+func (self *Server) SetSecureAdmin(secure bool) {
+	self.secureAdmin = secure
+}
+
+func (self *Server) listenTLS() {
+	cert, err := tls.LoadX509KeyPair(self.tlsCertFile, self.tlsKeyFile)
+	utils.HandleError(err)
+
+	config := tls.Config{Certificates: []tls.Certificate{cert}}
+
+	tcpListener, err := self.listen(self.tlsAddr)
+	utils.HandleError(err)
+
+	listener := tls.NewListener(tcpListener, &config)
+	self.addListener(listener)
+
+	fmt.Println("TLS listening on", self.tlsAddr)
+
+	for {
+		conn, err := listener.Accept()
+
+		if err != nil && self.stopping() {
+			return
+		}
+
+		utils.HandleError(err)
+		fmt.Println("TLS client connected:", conn.RemoteAddr())
+		self.accept(conn)
+	}
+}
+
+// vim: nocindent
@@ -0,0 +1,433 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Cristofori/kmud/utils"
+	"github.com/Cristofori/kmud/websocket"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// How long a browser gets to send the headers of a request, and how long an
+// idle keep-alive connection is kept open
+const (
+	webHeaderTimeout = 10 * time.Second
+	webIdleTimeout   = time.Minute
+)
+
+// listenWeb serves the web client page, and accepts the WebSocket connections
+// it makes back to the server. Those connections carry an ordinary telnet
+// stream, so from here on they're treated exactly like a telnet client.
+func (self *Server) listenWeb() {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, webClientPage)
+	})
+
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+
+		if err != nil {
+			fmt.Println("WebSocket upgrade failed:", r.RemoteAddr, err)
+			return
+		}
+
+		fmt.Println("Web client connected:", conn.RemoteAddr())
+		self.accept(conn)
+	})
+
+	listener, err := self.listen(self.webAddr)
+	utils.HandleError(err)
+	self.addListener(listener)
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: webHeaderTimeout,
+		IdleTimeout:       webIdleTimeout,
+
+		// Connections count towards the limits from the moment they're
+		// accepted, until they're upgraded and handed over as a telnet
+		// connection that's counted from then on
+		ConnState: func(conn net.Conn, state http.ConnState) {
+			switch state {
+			case http.StateNew:
+				if !self.addHandshake(conn) {
+					fmt.Println("Web connection refused, too many connections:", conn.RemoteAddr())
+					conn.Close()
+				}
+			case http.StateHijacked, http.StateClosed:
+				self.removeHandshake(conn)
+			}
+		},
+	}
+
+	fmt.Println("Web client listening on", self.webAddr)
+	err = server.Serve(listener)
+
+	if !self.stopping() {
+		utils.HandleError(err)
+	}
+}
+
+// webClientPage is a minimal terminal for the browser. It speaks just enough
+// telnet to answer the server's negotiations, reports itself through MTTS as an
+// ANSI, UTF-8 capable client, and renders the basic ANSI colors.
+const webClientPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>kmud</title>
+<style>
+html, body { height: 100%; margin: 0; background: #000; color: #c0c0c0; }
+body { display: flex; flex-direction: column; font: 14px monospace; }
+#output { flex: 1; overflow-y: auto; padding: 4px; white-space: pre-wrap; word-wrap: break-word; }
+#input { border: 0; border-top: 1px solid #444; padding: 6px; background: #111; color: #fff; font: inherit; outline: none; }
+#output div { min-height: 1.2em; }
+#status { color: #888; }
+</style>
+</head>
+<body>
+<div id="output"><div id="status">Connecting...</div></div>
+<input id="input" type="text" autocomplete="off" autofocus>
+<script>
+(function() {
+	"use strict";
+
+	var IAC = 255, DONT = 254, DO = 253, WONT = 252, WILL = 251, SB = 250, SE = 240;
+	var ECHO = 1, TTYPE = 24;
+
+	// Answers to TTYPE SEND, the last one repeats once they run out
+	var terminalTypes = ["KMUD-WEB", "ANSI", "MTTS 5"]; // 5 = ANSI | UTF-8
+	var terminalTypeIndex = 0;
+
+	var maxLines = 2000;
+
+	var colors = ["#555", "#c00", "#0a0", "#aa0", "#33c", "#a0a", "#0aa", "#c0c0c0"];
+	var brightColors = ["#888", "#f55", "#5f5", "#ff5", "#77f", "#f5f", "#5ff", "#fff"];
+
+	var output = document.getElementById("output");
+	var input = document.getElementById("input");
+
+	var line = null;
+	var bold = false;
+	var foreground = -1;
+	var carriageReturn = false;
+
+	var history = [];
+	var historyIndex = 0;
+
+	var decoder = new TextDecoder("utf-8");
+
+	function newLine() {
+		line = document.createElement("div");
+		output.appendChild(line);
+
+		while (output.childNodes.length > maxLines) {
+			output.removeChild(output.firstChild);
+		}
+	}
+
+	function appendText(text) {
+		if (text === "") {
+			return;
+		}
+
+		if (carriageReturn) {
+			clearLine();
+		}
+
+		var span = document.createElement("span");
+		if (foreground >= 0) {
+			span.style.color = (bold ? brightColors : colors)[foreground];
+		} else if (bold) {
+			span.style.color = "#fff";
+		}
+		span.textContent = text;
+		line.appendChild(span);
+	}
+
+	function clearLine() {
+		carriageReturn = false;
+		while (line.firstChild) {
+			line.removeChild(line.firstChild);
+		}
+	}
+
+	function setGraphics(params) {
+		var codes = params === "" ? ["0"] : params.split(";");
+		for (var i = 0; i < codes.length; i++) {
+			var code = parseInt(codes[i], 10) || 0;
+
+			if (code === 0) {
+				bold = false;
+				foreground = -1;
+			} else if (code === 1) {
+				bold = true;
+			} else if (code === 22) {
+				bold = false;
+			} else if (code >= 30 && code <= 37) {
+				foreground = code - 30;
+			} else if (code === 39) {
+				foreground = -1;
+			}
+		}
+	}
+
+	// Renders decoded text, handling line endings and ANSI escape sequences.
+	// Escape sequences other than colors and erasing the line are ignored.
+	var pending = "";
+
+	function render(text) {
+		text = pending + text;
+		pending = "";
+
+		var scrolledToBottom = output.scrollTop + output.clientHeight >= output.scrollHeight - 4;
+		var start = 0;
+
+		for (var i = 0; i < text.length; i++) {
+			var c = text.charAt(i);
+
+			if (c === "\n") {
+				appendText(text.substring(start, i));
+				carriageReturn = false;
+				newLine();
+				start = i + 1;
+			} else if (c === "\r") {
+				appendText(text.substring(start, i));
+				carriageReturn = true;
+				start = i + 1;
+			} else if (c === "\x1b") {
+				appendText(text.substring(start, i));
+
+				var match = /^\x1b\[([0-9;]*)([@-~])/.exec(text.substring(i));
+				if (match === null) {
+					if (text.length - i < 16) {
+						// Incomplete sequence, wait for the rest of it
+						pending = text.substring(i);
+						start = text.length;
+						break;
+					}
+					start = i + 1;
+					continue;
+				}
+
+				if (match[2] === "m") {
+					setGraphics(match[1]);
+				} else if (match[2] === "K" && match[1] === "2") {
+					clearLine();
+				}
+
+				i += match[0].length - 1;
+				start = i + 1;
+			} else if (c < " " && c !== "\t") {
+				appendText(text.substring(start, i));
+				start = i + 1;
+			}
+		}
+
+		appendText(text.substring(start));
+
+		if (scrolledToBottom) {
+			output.scrollTop = output.scrollHeight;
+		}
+	}
+
+	var socket = null;
+
+	function send(bytes) {
+		if (socket !== null && socket.readyState === WebSocket.OPEN) {
+			socket.send(new Uint8Array(bytes));
+		}
+	}
+
+	function setPasswordMode(enabled) {
+		input.type = enabled ? "password" : "text";
+	}
+
+	function negotiate(command, option) {
+		if (command === WILL) {
+			if (option === ECHO) {
+				setPasswordMode(true);
+				send([IAC, DO, option]);
+			} else {
+				send([IAC, DONT, option]);
+			}
+		} else if (command === WONT) {
+			// Only echo was ever agreed to, so it's the only one to acknowledge
+			if (option === ECHO && input.type === "password") {
+				setPasswordMode(false);
+				send([IAC, DONT, option]);
+			}
+		} else if (command === DO) {
+			if (option === TTYPE) {
+				send([IAC, WILL, option]);
+			} else {
+				send([IAC, WONT, option]);
+			}
+		}
+	}
+
+	function subnegotiate(data) {
+		if (data.length >= 2 && data[0] === TTYPE && data[1] === 1) { // 1 = SEND
+			var name = terminalTypes[Math.min(terminalTypeIndex, terminalTypes.length - 1)];
+			terminalTypeIndex++;
+
+			var reply = [IAC, SB, TTYPE, 0]; // 0 = IS
+			for (var i = 0; i < name.length; i++) {
+				reply.push(name.charCodeAt(i));
+			}
+			reply.push(IAC, SE);
+			send(reply);
+		}
+	}
+
+	// Telnet parser state, carried between messages
+	var state = 0; // 0: data, 1: after IAC, 2: waiting for an option, 3: in SB, 4: IAC in SB
+	var command = 0;
+	var subData = [];
+
+	function receive(bytes) {
+		var text = [];
+
+		for (var i = 0; i < bytes.length; i++) {
+			var b = bytes[i];
+
+			switch (state) {
+			case 0:
+				if (b === IAC) {
+					state = 1;
+				} else {
+					text.push(b);
+				}
+				break;
+			case 1:
+				if (b === IAC) {
+					text.push(b);
+					state = 0;
+				} else if (b >= WILL && b <= DONT) {
+					command = b;
+					state = 2;
+				} else if (b === SB) {
+					subData = [];
+					state = 3;
+				} else {
+					state = 0;
+				}
+				break;
+			case 2:
+				negotiate(command, b);
+				state = 0;
+				break;
+			case 3:
+				if (b === IAC) {
+					state = 4;
+				} else {
+					subData.push(b);
+				}
+				break;
+			case 4:
+				if (b === SE) {
+					subnegotiate(subData);
+					state = 0;
+				} else {
+					subData.push(b);
+					state = 3;
+				}
+				break;
+			}
+		}
+
+		render(decoder.decode(new Uint8Array(text), { stream: true }));
+	}
+
+	function status(message) {
+		newLine();
+		line.style.color = "#888";
+		line.textContent = message;
+		newLine();
+		output.scrollTop = output.scrollHeight;
+	}
+
+	function connect() {
+		var protocol = location.protocol === "https:" ? "wss:" : "ws:";
+		socket = new WebSocket(protocol + "//" + location.host + "/ws");
+		socket.binaryType = "arraybuffer";
+
+		socket.onopen = function() {
+			output.innerHTML = "";
+			newLine();
+			input.focus();
+		};
+
+		socket.onmessage = function(event) {
+			receive(new Uint8Array(event.data));
+		};
+
+		socket.onclose = function() {
+			status("Connection closed");
+			setPasswordMode(false);
+			socket = null;
+		};
+	}
+
+	input.addEventListener("keydown", function(event) {
+		if (event.key === "Enter") {
+			var value = input.value;
+			input.value = "";
+
+			if (input.type === "text") {
+				render(value + "\r\n");
+
+				if (value !== "" && history[history.length - 1] !== value) {
+					history.push(value);
+				}
+			}
+			historyIndex = history.length;
+
+			var encoded = new TextEncoder().encode(value + "\r\n");
+			var bytes = [];
+			for (var i = 0; i < encoded.length; i++) {
+				bytes.push(encoded[i]);
+				if (encoded[i] === IAC) {
+					bytes.push(IAC);
+				}
+			}
+			send(bytes);
+		} else if (event.key === "ArrowUp" && input.type === "text") {
+			if (historyIndex > 0) {
+				historyIndex--;
+				input.value = history[historyIndex];
+			}
+			event.preventDefault();
+		} else if (event.key === "ArrowDown" && input.type === "text") {
+			if (historyIndex < history.length) {
+				historyIndex++;
+				input.value = historyIndex < history.length ? history[historyIndex] : "";
+			}
+			event.preventDefault();
+		}
+	});
+
+	document.body.addEventListener("click", function() {
+		if (window.getSelection().toString() === "") {
+			input.focus();
+		}
+	});
+
+	connect();
+})();
+</script>
+</body>
+</html>
+`
+
+// vim: nocindent
@@ -19,7 +19,7 @@ func (ah *actionHandler) handleAction(action string, args []string) {
 			if ah.session.room.HasExit(direction) {
 				newRoom, err := model.MoveCharacter(&ah.session.player.Character, direction)
 				if err == nil {
-					ah.session.room = newRoom
+					ah.session.setRoom(newRoom)
 					ah.session.printRoom()
 				} else {
 					ah.session.printError(err.Error())
@@ -116,13 +116,13 @@ func (ah *actionHandler) Disconnect(args []string) {
 }
 
 func (ah *actionHandler) Talk(args []string) {
-	if len(args) != 1 {
+	if len(args) == 0 {
 		ah.session.printError("Usage: talk <NPC name>")
 		return
 	}
 
 	npcList := model.NpcsIn(ah.session.room)
-	index := utils.BestMatch(args[0], npcList.Characters().Names())
+	index := utils.BestMatch(strings.Join(args, " "), npcList.Characters().Names())
 
 	if index == -1 {
 		ah.session.printError("Not found")
@@ -180,18 +180,19 @@ func (ah *actionHandler) Pickup(args []string) {
 		ah.session.printError("Usage: take <item name>")
 	}
 
-	if len(args) != 1 {
+	if len(args) == 0 {
 		takeUsage()
 		return
 	}
 
+	name := strings.Join(args, " ")
 	itemsInRoom := model.GetItems(ah.session.room.GetItemIds())
-	index := utils.BestMatch(args[0], database.ItemNames(itemsInRoom))
+	index := utils.BestMatch(name, database.ItemNames(itemsInRoom))
 
 	if index == -2 {
 		ah.session.printError("Which one do you mean?")
 	} else if index == -1 {
-		ah.session.printError("Item %s not found", args[0])
+		ah.session.printError("Item %s not found", name)
 	} else {
 		item := itemsInRoom[index]
 		ah.session.player.AddItem(item)
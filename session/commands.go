@@ -5,9 +5,12 @@ import (
 	"gopkg.in/mgo.v2/bson"
 	"github.com/Cristofori/kmud/database"
 	"github.com/Cristofori/kmud/model"
+	"github.com/Cristofori/kmud/telnet"
 	"github.com/Cristofori/kmud/utils"
+	"golang.org/x/crypto/ssh"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type commandHandler struct {
@@ -105,12 +108,59 @@ func toggleExitMenu(room *database.Room) *utils.Menu {
 	return menu
 }
 
+// The permissions needed to run commands, commands that aren't listed are
+// available to every player
+var commandPermissions = map[string]database.Permission{
+	"room":        database.PermissionBuild,
+	"zone":        database.PermissionBuild,
+	"area":        database.PermissionBuild,
+	"dr":          database.PermissionBuild,
+	"destroyroom": database.PermissionBuild,
+	"npc":         database.PermissionBuild,
+	"create":      database.PermissionBuild,
+	"destroyitem": database.PermissionBuild,
+	"cash":        database.PermissionBuild,
+	"roomid":      database.PermissionBuild,
+	"prop":        database.PermissionBuild,
+	"setprop":     database.PermissionBuild,
+	"delprop":     database.PermissionBuild,
+	"tel":         database.PermissionTeleport,
+	"teleport":    database.PermissionTeleport,
+	"role":        database.PermissionRoles,
+	"restore":     database.PermissionAdmin,
+	"ban":         database.PermissionModerate,
+	"unban":       database.PermissionModerate,
+	"banip":       database.PermissionModerate,
+	"unbanip":     database.PermissionModerate,
+	"mute":        database.PermissionModerate,
+	"unmute":      database.PermissionModerate,
+	"jail":        database.PermissionModerate,
+	"release":     database.PermissionModerate,
+	"sanctions":   database.PermissionModerate,
+}
+
+// allowed returns true if the session's user may run the given command
+func (ch *commandHandler) allowed(command string) bool {
+	permission, found := commandPermissions[strings.ToLower(command)]
+	return !found || ch.session.user.HasPermission(permission)
+}
+
 func (ch *commandHandler) handleCommand(command string, args []string) {
 	if command[0] == '/' {
+		if !ch.session.user.HasPermission(database.PermissionBuild) {
+			ch.session.printError("You don't have permission to build")
+			return
+		}
+
 		ch.quickRoom(command[1:])
 		return
 	}
 
+	if !ch.allowed(command) {
+		ch.session.printError("You don't have permission to use that command")
+		return
+	}
+
 	found := utils.FindAndCallMethod(ch, command, args)
 
 	if !found {
@@ -145,6 +195,7 @@ func (ch *commandHandler) Room(args []string) {
 	menu.AddAction("d", "Description")
 	menu.AddAction("e", "Exits")
 	menu.AddAction("a", "Area")
+	menu.AddAction("j", "Toggle jail")
 
 	for {
 		choice, _ := ch.session.execMenu(menu)
@@ -191,6 +242,16 @@ func (ch *commandHandler) Room(args []string) {
 					}
 				}
 			}
+		case "j":
+			jail := !ch.session.room.IsJail()
+			ch.session.room.SetJail(jail)
+
+			if jail {
+				ch.session.printLine("This room is now a jail")
+			} else {
+				ch.session.printLine("This room is no longer a jail")
+			}
+
 		case "a":
 			menu := utils.NewMenu("Change Area")
 			menu.AddAction("n", "None")
@@ -287,7 +348,8 @@ func (ch *commandHandler) Map(args []string) {
 		}
 	}
 
-	ch.session.printLine(utils.TrimEmptyRows(builder.toString()))
+	columns, _ := ch.session.user.WindowSize()
+	ch.session.printLine(utils.TrimEmptyRows(builder.toString(columns)))
 }
 
 func (ch *commandHandler) Zone(args []string) {
@@ -298,10 +360,20 @@ func (ch *commandHandler) Zone(args []string) {
 			ch.session.printLineColor(utils.ColorBlue, "Zones")
 			ch.session.printLineColor(utils.ColorBlue, "-----")
 			for _, zone := range model.GetZones() {
-				ch.session.printLine(zone.GetName())
+				if zone.IsStartingArea() {
+					ch.session.printLine(zone.GetName() + " (starting area)")
+				} else {
+					ch.session.printLine(zone.GetName())
+				}
 			}
+		} else if args[0] == "start" {
+			ch.session.currentZone().SetStartRoom(ch.session.room.GetId())
+			ch.session.printLine("New characters can now start out in %s, in this room", ch.session.currentZone().GetName())
+		} else if args[0] == "nostart" {
+			ch.session.currentZone().SetStartRoom("")
+			ch.session.printLine("%s is no longer a starting area", ch.session.currentZone().GetName())
 		} else {
-			ch.session.printError("Usage: /zone [list|rename <name>|new <name>]")
+			ch.session.printError("Usage: /zone [list|start|nostart|rename <name>|new <name>]")
 		}
 	} else if len(args) == 2 {
 		if args[0] == "rename" {
@@ -314,6 +386,13 @@ func (ch *commandHandler) Zone(args []string) {
 
 			ch.session.currentZone().SetName(args[1])
 		} else if args[0] == "new" {
+			// Checked up front, so that a jailed builder doesn't leave an
+			// empty zone behind
+			if err := model.CheckJailed(&ch.session.player.Character); err != nil {
+				ch.session.printError(err.Error())
+				return
+			}
+
 			newZone, err := model.CreateZone(args[1])
 
 			if err != nil {
@@ -324,9 +403,12 @@ func (ch *commandHandler) Zone(args []string) {
 			newRoom, err := model.CreateRoom(newZone, database.Coordinate{X: 0, Y: 0, Z: 0})
 			utils.PanicIfError(err)
 
-			model.MoveCharacterToRoom(&ch.session.player.Character, newRoom)
+			if err := model.MoveCharacterToRoom(&ch.session.player.Character, newRoom); err != nil {
+				ch.session.printError(err.Error())
+				return
+			}
 
-			ch.session.room = newRoom
+			ch.session.setRoom(newRoom)
 
 			ch.session.printRoom()
 		}
@@ -341,7 +423,9 @@ func (ch *commandHandler) Broadcast(args []string) {
 	if len(args) == 0 {
 		ch.session.printError("Nothing to say")
 	} else {
-		model.BroadcastMessage(&ch.session.player.Character, strings.Join(args, " "))
+		if err := model.BroadcastMessage(&ch.session.player.Character, strings.Join(args, " ")); err != nil {
+			ch.session.printError(err.Error())
+		}
 	}
 }
 
@@ -353,12 +437,16 @@ func (ch *commandHandler) Say(args []string) {
 	if len(args) == 0 {
 		ch.session.printError("Nothing to say")
 	} else {
-		model.Say(&ch.session.player.Character, strings.Join(args, " "))
+		if err := model.Say(&ch.session.player.Character, strings.Join(args, " ")); err != nil {
+			ch.session.printError(err.Error())
+		}
 	}
 }
 
 func (ch *commandHandler) Me(args []string) {
-	model.Emote(&ch.session.player.Character, strings.Join(args, " "))
+	if err := model.Emote(&ch.session.player.Character, strings.Join(args, " ")); err != nil {
+		ch.session.printError(err.Error())
+	}
 }
 
 func (ch *commandHandler) W(args []string) {
@@ -384,7 +472,9 @@ func (ch *commandHandler) Whisper(args []string) {
 	}
 
 	message := strings.Join(args[1:], " ")
-	model.Tell(&ch.session.player.Character, &targetChar.Character, message)
+	if err := model.Tell(&ch.session.player.Character, &targetChar.Character, message); err != nil {
+		ch.session.printError(err.Error())
+	}
 }
 
 func (ch *commandHandler) Tel(args []string) {
@@ -453,7 +543,7 @@ func (ch *commandHandler) Teleport(args []string) {
 	newRoom, err := model.MoveCharacterToLocation(&ch.session.player.Character, newZone, database.Coordinate{X: x, Y: y, Z: z})
 
 	if err == nil {
-		ch.session.room = newRoom
+		ch.session.setRoom(newRoom)
 		ch.session.printRoom()
 	} else {
 		ch.session.printError(err.Error())
@@ -526,6 +616,425 @@ func (ch *commandHandler) ColorMode(args []string) {
 	}
 }
 
+func (ch *commandHandler) CharMode(args []string) {
+	usage := func() {
+		ch.session.printLine("Usage: /charmode [on|off]")
+	}
+
+	if len(args) == 0 {
+		state := "off"
+		if ch.session.user.GetCharMode() {
+			state = "on"
+		}
+		ch.session.printLine("Character mode is %s", state)
+		return
+	} else if len(args) != 1 {
+		usage()
+		return
+	}
+
+	var enabled bool
+
+	switch strings.ToLower(args[0]) {
+	case "on":
+		enabled = true
+	case "off":
+		enabled = false
+	default:
+		usage()
+		return
+	}
+
+	if !ch.session.setCharMode(enabled) {
+		ch.session.printError("Your connection doesn't support character mode")
+		return
+	}
+
+	ch.session.user.SetCharMode(enabled)
+
+	if enabled {
+		ch.session.printLine("Character mode on, use tab to complete and the arrow keys for history")
+	} else {
+		ch.session.printLine("Character mode off")
+	}
+}
+
+// SSHKey manages the public keys that can be used to log in over SSH without
+// a password
+func (ch *commandHandler) SSHKey(args []string) {
+	usage := func() {
+		ch.session.printError("Usage: /sshkey [add <public key>|remove <number>]")
+	}
+
+	keys := ch.session.user.GetPublicKeys()
+
+	if len(args) == 0 {
+		if len(keys) == 0 {
+			ch.session.printLine("No SSH keys registered")
+		}
+
+		for i, key := range keys {
+			fingerprint := key
+			if publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err == nil {
+				fingerprint = ssh.FingerprintSHA256(publicKey)
+			}
+			ch.session.printLine("%v. %s", i+1, fingerprint)
+		}
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "add":
+		if len(args) < 3 {
+			usage()
+			return
+		}
+
+		// The key is everything after "add", as pasted from a .pub file
+		publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(args[1:], " ")))
+
+		if err != nil {
+			ch.session.printError("Invalid public key: %v", err)
+			return
+		}
+
+		ch.session.user.AddPublicKey(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))))
+		ch.session.printLine("Added SSH key %s", ssh.FingerprintSHA256(publicKey))
+
+	case "remove":
+		if len(args) != 2 {
+			usage()
+			return
+		}
+
+		index, err := strconv.Atoi(args[1])
+
+		if err != nil || index < 1 || index > len(keys) {
+			ch.session.printError("No SSH key with that number")
+			return
+		}
+
+		ch.session.user.RemovePublicKey(keys[index-1])
+		ch.session.printLine("SSH key removed")
+
+	default:
+		usage()
+	}
+}
+
+// Role lists a user's roles, or grants or revokes one
+func (ch *commandHandler) Role(args []string) {
+	usage := func() {
+		ch.session.printError("Usage: /role <user> [grant|revoke <role>]")
+	}
+
+	if len(args) != 1 && len(args) != 3 {
+		usage()
+		return
+	}
+
+	user := model.GetUserByName(args[0])
+
+	if user == nil {
+		ch.session.printError("User not found: %s", args[0])
+		return
+	}
+
+	if len(args) == 1 {
+		var roles []string
+		for _, role := range user.GetRoles() {
+			roles = append(roles, string(role))
+		}
+
+		ch.session.printLine("%s: %s", user.GetName(), strings.Join(roles, ", "))
+		return
+	}
+
+	role, found := database.ParseRole(args[2])
+
+	if !found {
+		var roles []string
+		for _, role := range database.Roles {
+			roles = append(roles, string(role))
+		}
+
+		ch.session.printError("Unknown role, the roles are: %s", strings.Join(roles, ", "))
+		return
+	}
+
+	action := ""
+
+	switch strings.ToLower(args[1]) {
+	case "grant":
+		user.GrantRole(role)
+		ch.session.printLine("%s is now a %s", user.GetName(), role)
+		action = "granted to"
+	case "revoke":
+		// Makes sure there's always someone left to grant roles
+		if user == ch.session.user && role == database.RoleAdmin {
+			ch.session.printError("You can't revoke your own admin role")
+			return
+		}
+
+		user.RevokeRole(role)
+		ch.session.printLine("%s is no longer a %s", user.GetName(), role)
+		action = "revoked from"
+	default:
+		usage()
+		return
+	}
+
+	fmt.Printf("Role %s %s %s by %s\n", role, action, user.GetName(), ch.session.user.GetName())
+}
+
+// Restore lists the deleted users and characters that can still be restored,
+// or restores one of them
+func (ch *commandHandler) Restore(args []string) {
+	usage := func() {
+		ch.session.printError("Usage: /restore [user|char <name>]")
+	}
+
+	deleted := func(when time.Time) string {
+		return when.Format("2006-01-02 15:04")
+	}
+
+	if len(args) == 0 {
+		users := model.GetDeletedUsers()
+		pcs := model.GetDeletedPlayerCharacters()
+
+		if len(users) == 0 && len(pcs) == 0 {
+			ch.session.printLine("There's nothing to restore")
+			return
+		}
+
+		for _, user := range users {
+			ch.session.printLine("User %s, deleted %s", user.GetName(), deleted(user.DeletedAt()))
+		}
+
+		for _, pc := range pcs {
+			ch.session.printLine("Character %s, deleted %s", pc.GetName(), deleted(pc.DeletedAt()))
+		}
+
+		return
+	}
+
+	if len(args) != 2 {
+		usage()
+		return
+	}
+
+	name := utils.FormatName(args[1])
+	var err error
+
+	// The same name can have been deleted more than once, the latest one is
+	// restored
+	switch strings.ToLower(args[0]) {
+	case "user":
+		var found *database.User
+		for _, user := range model.GetDeletedUsers() {
+			if user.GetName() == name && (found == nil || user.DeletedAt().After(found.DeletedAt())) {
+				found = user
+			}
+		}
+
+		if found == nil {
+			ch.session.printError("No deleted user named %s", name)
+			return
+		}
+
+		err = model.RestoreUser(found)
+	case "char":
+		var found *database.PlayerChar
+		for _, pc := range model.GetDeletedPlayerCharacters() {
+			if pc.GetName() == name && (found == nil || pc.DeletedAt().After(found.DeletedAt())) {
+				found = pc
+			}
+		}
+
+		if found == nil {
+			ch.session.printError("No deleted character named %s", name)
+			return
+		}
+
+		err = model.RestorePlayerCharacter(found)
+	default:
+		usage()
+		return
+	}
+
+	if err != nil {
+		ch.session.printError(err.Error())
+		return
+	}
+
+	ch.session.printLine("Restored %s", name)
+	fmt.Printf("Restored %s %s by %s\n", strings.ToLower(args[0]), name, ch.session.user.GetName())
+}
+
+// impose puts a sanction of the given kind on the user named in args, for the
+// duration given there and with the rest of args as the reason
+func (ch *commandHandler) impose(kind database.SanctionKind, args []string) {
+	if len(args) < 2 {
+		ch.session.printError("Usage: /%s <user> <duration|forever> [reason]", kind)
+		return
+	}
+
+	user := model.GetUserByName(args[0])
+
+	if user == nil {
+		ch.session.printError("User not found: %s", args[0])
+		return
+	}
+
+	if user == ch.session.user {
+		ch.session.printError("You can't %s yourself", kind)
+		return
+	}
+
+	duration, err := utils.ParseDuration(args[1])
+
+	if err != nil {
+		ch.session.printError(err.Error())
+		return
+	}
+
+	sanction := database.NewSanction(duration, strings.Join(args[2:], " "), ch.session.user.GetName())
+
+	if err := model.Impose(user, kind, sanction); err != nil {
+		ch.session.printError(err.Error())
+		return
+	}
+
+	ch.session.printLine("%s: %s %s", user.GetName(), kind, sanction)
+	fmt.Printf("Sanction %s on %s %s\n", kind, user.GetName(), sanction)
+}
+
+// lift removes the sanction of the given kind from the user named in args
+func (ch *commandHandler) lift(kind database.SanctionKind, args []string) {
+	if len(args) != 1 {
+		ch.session.printError("Usage: /un%s <user>", kind)
+		return
+	}
+
+	user := model.GetUserByName(args[0])
+
+	if user == nil {
+		ch.session.printError("User not found: %s", args[0])
+		return
+	}
+
+	if !model.Lift(user, kind) {
+		ch.session.printError("%s isn't under a %s", user.GetName(), kind)
+		return
+	}
+
+	ch.session.printLine("%s: %s lifted", user.GetName(), kind)
+	fmt.Printf("Sanction %s on %s lifted by %s\n", kind, user.GetName(), ch.session.user.GetName())
+}
+
+// Ban keeps a user from logging in
+func (ch *commandHandler) Ban(args []string) {
+	ch.impose(database.SanctionBan, args)
+}
+
+func (ch *commandHandler) Unban(args []string) {
+	ch.lift(database.SanctionBan, args)
+}
+
+// Mute keeps a user from saying, telling, broadcasting or emoting anything
+func (ch *commandHandler) Mute(args []string) {
+	ch.impose(database.SanctionMute, args)
+}
+
+func (ch *commandHandler) Unmute(args []string) {
+	ch.lift(database.SanctionMute, args)
+}
+
+// Jail takes a user's characters to the jail room, and keeps them there
+func (ch *commandHandler) Jail(args []string) {
+	ch.impose(database.SanctionJail, args)
+}
+
+func (ch *commandHandler) Release(args []string) {
+	ch.lift(database.SanctionJail, args)
+}
+
+// BanIP keeps everyone connecting from an address range out
+func (ch *commandHandler) BanIP(args []string) {
+	if len(args) < 2 {
+		ch.session.printError("Usage: /banip <address[/bits]> <duration|forever> [reason]")
+		return
+	}
+
+	network, err := utils.ParseAddressRange(args[0])
+
+	if err != nil {
+		ch.session.printError(err.Error())
+		return
+	}
+
+	duration, err := utils.ParseDuration(args[1])
+
+	if err != nil {
+		ch.session.printError(err.Error())
+		return
+	}
+
+	sanction := database.NewSanction(duration, strings.Join(args[2:], " "), ch.session.user.GetName())
+	model.BanAddresses(network, sanction)
+
+	ch.session.printLine("%s: ban %s", network, sanction)
+	fmt.Printf("Sanction ban on %s %s\n", network, sanction)
+}
+
+func (ch *commandHandler) UnbanIP(args []string) {
+	if len(args) != 1 {
+		ch.session.printError("Usage: /unbanip <address[/bits]>")
+		return
+	}
+
+	network, err := utils.ParseAddressRange(args[0])
+
+	if err != nil {
+		ch.session.printError(err.Error())
+		return
+	}
+
+	for _, ban := range model.GetBans() {
+		if ban.GetRange() == network.String() {
+			model.DeleteObject(ban)
+			ch.session.printLine("%s: ban lifted", network)
+			fmt.Printf("Sanction ban on %s lifted by %s\n", network, ch.session.user.GetName())
+			return
+		}
+	}
+
+	ch.session.printError("%s isn't banned", network)
+}
+
+// Sanctions lists the bans, mutes and jail sentences that are in effect
+func (ch *commandHandler) Sanctions(args []string) {
+	found := false
+
+	for _, user := range model.GetUsers() {
+		for _, kind := range []database.SanctionKind{database.SanctionBan, database.SanctionMute, database.SanctionJail} {
+			if sanction, active := user.GetSanction(kind); active {
+				ch.session.printLine("%s: %s %s", user.GetName(), kind, sanction)
+				found = true
+			}
+		}
+	}
+
+	for _, ban := range model.GetBans() {
+		ch.session.printLine("%s: ban %s", ban.GetRange(), ban.GetSanction())
+		found = true
+	}
+
+	if !found {
+		ch.session.printLine("No one is banned, muted or jailed")
+	}
+}
+
 func (ch *commandHandler) DR(args []string) {
 	ch.DestroyRoom(args)
 }
@@ -725,11 +1234,15 @@ func (ch *commandHandler) Cash(args []string) {
 }
 
 func (ch *commandHandler) WS(args []string) { // WindowSize
+	if ch.session.negotiatesOptions() && !ch.session.optionEnabled(telnet.WS) {
+		ch.session.printLine("Your client hasn't reported its window size, using the default")
+	}
+
 	width, height := ch.session.user.WindowSize()
 
 	header := fmt.Sprintf("Width: %v, Height: %v", width, height)
 
-	topBar := header + " " + strings.Repeat("-", int(width)-2-len(header)) + "+"
+	topBar := header + " " + strings.Repeat("-", int(width)-2-utils.DisplayWidth(header)) + "+"
 	bottomBar := "+" + strings.Repeat("-", int(width)-2) + "+"
 	outline := "|" + strings.Repeat(" ", int(width)-2) + "|"
 
@@ -744,6 +1257,37 @@ func (ch *commandHandler) WS(args []string) { // WindowSize
 
 func (ch *commandHandler) TT(args []string) { // TerminalType
 	ch.session.printLine("Terminal type: %s", ch.session.user.TerminalType())
+
+	caps := ch.session.user.GetCapabilities()
+
+	var features []string
+	addIf := func(enabled bool, name string) {
+		if enabled {
+			features = append(features, name)
+		}
+	}
+
+	addIf(caps.ANSI, "ANSI")
+	addIf(caps.VT100, "VT100")
+	addIf(caps.UTF8, "UTF-8")
+	addIf(caps.Colors256, "256 colors")
+	addIf(caps.TrueColor, "True color")
+	addIf(caps.MouseTracking, "Mouse tracking")
+	addIf(caps.ScreenReader, "Screen reader")
+	addIf(caps.Proxy, "Proxy")
+	addIf(caps.SSL, "SSL")
+
+	if len(features) == 0 {
+		features = append(features, "None detected")
+	}
+
+	ch.session.printLine("Capabilities: %s", strings.Join(features, ", "))
+
+	if ch.session.secure() {
+		ch.session.printLine("Connection: Encrypted")
+	} else {
+		ch.session.printLine("Connection: Not encrypted")
+	}
 }
 
 func (ch *commandHandler) Silent(args []string) {
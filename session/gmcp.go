@@ -0,0 +1,105 @@
+package session
+
+import (
+	"github.com/Cristofori/kmud/database"
+	"github.com/Cristofori/kmud/model"
+)
+
+// gmcpSender is implemented by connections that are able to send GMCP
+// out-of-band messages to the client
+type gmcpSender interface {
+	SendGMCP(module string, data interface{}) error
+}
+
+type gmcpVitals struct {
+	HitPoints int `json:"hp"`
+	Health    int `json:"maxhp"`
+}
+
+type gmcpCoordinate struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	Z int `json:"z"`
+}
+
+type gmcpRoomInfo struct {
+	Id     string            `json:"num"`
+	Name   string            `json:"name"`
+	Zone   string            `json:"zone"`
+	Area   string            `json:"area"`
+	Coords gmcpCoordinate    `json:"coords"`
+	Exits  map[string]string `json:"exits"`
+}
+
+type gmcpChannelText struct {
+	Channel string `json:"channel"`
+	Talker  string `json:"talker"`
+	Text    string `json:"text"`
+}
+
+func (session *Session) sendGMCP(module string, data interface{}) {
+	if sender, ok := session.conn.(gmcpSender); ok {
+		sender.SendGMCP(module, data)
+	}
+}
+
+func (session *Session) sendVitals() {
+	session.sendGMCP("Char.Vitals", gmcpVitals{
+		HitPoints: session.player.GetHitPoints(),
+		Health:    session.player.GetHealth(),
+	})
+}
+
+func (session *Session) sendRoomInfo() {
+	room := session.room
+	zone := session.currentZone()
+
+	info := gmcpRoomInfo{
+		Id:    room.GetId().Hex(),
+		Name:  room.GetTitle(),
+		Zone:  zone.GetName(),
+		Exits: map[string]string{},
+	}
+
+	if area := model.GetArea(room.GetAreaId()); area != nil {
+		info.Area = area.GetName()
+	}
+
+	loc := room.GetLocation()
+	info.Coords = gmcpCoordinate{X: loc.X, Y: loc.Y, Z: loc.Z}
+
+	for _, dir := range room.GetExits() {
+		// Exits that don't lead anywhere yet are reported with an empty id
+		exitId := ""
+		if next := model.GetRoomByLocation(room.NextLocation(dir), zone); next != nil {
+			exitId = next.GetId().Hex()
+		}
+
+		info.Exits[database.DirectionToAbbreviation(dir)] = exitId
+	}
+
+	session.sendGMCP("Room.Info", info)
+}
+
+// sendChannelText forwards communication events to the client's chat capture
+func (session *Session) sendChannelText(event model.Event) {
+	var text gmcpChannelText
+
+	switch event.Type() {
+	case model.SayEventType:
+		sayEvent := event.(model.SayEvent)
+		text = gmcpChannelText{"say", sayEvent.Character.GetName(), sayEvent.Message}
+	case model.TellEventType:
+		tellEvent := event.(model.TellEvent)
+		text = gmcpChannelText{"tell", tellEvent.From.GetName(), tellEvent.Message}
+	case model.BroadcastEventType:
+		broadcastEvent := event.(model.BroadcastEvent)
+		text = gmcpChannelText{"broadcast", broadcastEvent.Character.GetName(), broadcastEvent.Message}
+	default:
+		return
+	}
+
+	session.sendGMCP("Comm.Channel.Text", text)
+}
+
+// vim: nocindent
@@ -3,6 +3,7 @@ package session
 import (
 	"github.com/Cristofori/kmud/database"
 	"github.com/Cristofori/kmud/utils"
+	"strings"
 )
 
 type mapBuilder struct {
@@ -102,16 +103,26 @@ func (self *mapBuilder) addRoom(room *database.Room, x int, y int, z int) {
 	addIfExists(database.DirectionNorthWest, x-1, y-1)
 }
 
-func (self *mapBuilder) toString() string {
+// toString renders the map, cutting each row off at the given number of
+// terminal columns
+func (self *mapBuilder) toString(columns int) string {
 	str := ""
 
 	for z := 0; z < self.depth; z++ {
 		var rows []string
 		for y := 0; y < self.height; y++ {
 			row := ""
+			rowWidth := 0
 			for x := 0; x < self.width; x++ {
 				tile := self.data[z][y][x].toString()
+				tileWidth := utils.DisplayWidth(tile)
+
+				if rowWidth+tileWidth > columns {
+					break
+				}
+
 				row = row + tile
+				rowWidth += tileWidth
 			}
 			rows = append(rows, row)
 		}
@@ -119,7 +130,7 @@ func (self *mapBuilder) toString() string {
 		rows = utils.TrimLowerRows(rows)
 
 		if self.depth > 1 {
-			divider := utils.Colorize(utils.ColorWhite, "================================================================================\r\n")
+			divider := utils.Colorize(utils.ColorWhite, strings.Repeat("=", columns)+"\r\n")
 			rows = append(rows, divider)
 		}
 
@@ -6,11 +6,13 @@ import (
 	"io"
 	"github.com/Cristofori/kmud/database"
 	"github.com/Cristofori/kmud/model"
+	"github.com/Cristofori/kmud/telnet"
 	"github.com/Cristofori/kmud/utils"
 	"strconv"
 	// "log"
 	// "os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -22,11 +24,14 @@ type Session struct {
 
 	prompt string
 
-	userInputChannel chan string
-	inputModeChannel chan userInputMode
-	prompterChannel  chan utils.Prompter
-	panicChannel     chan interface{}
-	eventChannel     chan model.Event
+	reader            *inputReader
+	disconnectChannel chan io.ReadWriter
+	reattachChannel   chan io.ReadWriter
+	doneChannel       chan bool
+	eventChannel      chan model.Event
+
+	// Runs while the character is link-dead, logging it out once it fires
+	linkDeadTimer *time.Timer
 
 	silentMode bool
 
@@ -51,10 +56,9 @@ func NewSession(conn io.ReadWriter, user *database.User, player *database.Player
 
 	session.prompt = "%h/%H> "
 
-	session.userInputChannel = make(chan string)
-	session.inputModeChannel = make(chan userInputMode)
-	session.prompterChannel = make(chan utils.Prompter)
-	session.panicChannel = make(chan interface{})
+	session.disconnectChannel = make(chan io.ReadWriter)
+	session.reattachChannel = make(chan io.ReadWriter)
+	session.doneChannel = make(chan bool)
 	session.eventChannel = model.Register()
 
 	session.silentMode = false
@@ -71,6 +75,56 @@ func NewSession(conn io.ReadWriter, user *database.User, player *database.Player
 	return &session
 }
 
+// Minimum time between two commands from a player
+var inputInterval = 200 * time.Millisecond
+
+// Hit points regained every timer tick while out of combat
+var regenAmount = 5
+
+func SetInputInterval(interval time.Duration) {
+	inputInterval = interval
+}
+
+func SetRegenAmount(amount int) {
+	regenAmount = amount
+}
+
+// How long a character stays in the world after its connection drops, waiting
+// for its user to log back in
+var linkDeadTimeout = 10 * time.Minute
+
+func SetLinkDeadTimeout(timeout time.Duration) {
+	linkDeadTimeout = timeout
+}
+
+// The sessions that are running, by user
+var sessions = map[bson.ObjectId]*Session{}
+var sessionsMutex sync.Mutex
+
+// ForUser returns the user's running session, nil if they're not in the game
+func ForUser(user *database.User) *Session {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	return sessions[user.GetId()]
+}
+
+func (session *Session) register() {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	sessions[session.user.GetId()] = session
+}
+
+func (session *Session) unregister() {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	if sessions[session.user.GetId()] == session {
+		delete(sessions, session.user.GetId())
+	}
+}
+
 type userInputMode int
 
 const (
@@ -78,43 +132,191 @@ const (
 	RawUserInput   userInputMode = iota
 )
 
-func (session *Session) Exec() {
-	defer model.Unregister(session.eventChannel)
-	defer model.Logout(session.player)
+type inputRequest struct {
+	mode     userInputMode
+	prompter utils.Prompter
+}
 
-	session.printLineColor(utils.ColorWhite, "Welcome, "+session.player.GetName())
-	session.printRoom()
+// inputReader reads the user's input from one connection. A new one is
+// started whenever the session moves to another connection.
+type inputReader struct {
+	conn     io.ReadWriter
+	requests chan inputRequest
+	input    chan string
+	stop     chan bool
+}
 
-	// Main routine in charge of actually reading input from the connection object,
+// startReader starts reading input from the given connection, replacing the
+// current reader if there is one
+func (session *Session) startReader(conn io.ReadWriter) {
+	if session.reader != nil {
+		close(session.reader.stop)
+	}
+
+	reader := &inputReader{
+		conn:     conn,
+		requests: make(chan inputRequest),
+		input:    make(chan string),
+		stop:     make(chan bool),
+	}
+
+	session.reader = reader
+
+	// Routine in charge of actually reading input from the connection object,
 	// also has built in throttling to limit how fast we are allowed to process
 	// commands from the user.
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
-				session.panicChannel <- r
+				select {
+				case session.disconnectChannel <- reader.conn:
+				case <-reader.stop:
+				}
 			}
 		}()
 
-		throttler := utils.NewThrottler(200 * time.Millisecond)
+		throttler := utils.NewThrottler(inputInterval)
 
 		for {
-			mode := <-session.inputModeChannel
-			prompter := <-session.prompterChannel
+			var request inputRequest
+
+			select {
+			case request = <-reader.requests:
+			case <-reader.stop:
+				return
+			}
+
 			input := ""
 
-			switch mode {
+			switch request.mode {
 			case CleanUserInput:
-				input = utils.GetUserInputP(session.conn, prompter, session.user.GetColorMode())
+				input = utils.GetUserInputP(reader.conn, request.prompter, session.user.GetColorMode())
 			case RawUserInput:
-				input = utils.GetRawUserInputP(session.conn, prompter, session.user.GetColorMode())
+				input = utils.GetRawUserInputP(reader.conn, request.prompter, session.user.GetColorMode())
 			default:
 				panic("Unhandled case in switch statement (userInputMode)")
 			}
 
 			throttler.Sync()
-			session.userInputChannel <- input
+
+			select {
+			case reader.input <- input:
+			case <-reader.stop:
+				return
+			}
 		}
 	}()
+}
+
+// Conn returns the connection the session is running on, which changes when
+// the user logs back in from another one
+func (session *Session) Conn() io.ReadWriter {
+	return session.conn
+}
+
+// Reattach moves the session to the given connection, after its user has
+// logged in again. The character's previous connection, if it's still alive,
+// is closed. Returns false if the session has already ended.
+func (session *Session) Reattach(conn io.ReadWriter) bool {
+	select {
+	case session.reattachChannel <- conn:
+		return true
+	case <-session.doneChannel:
+		return false
+	}
+}
+
+// Wait blocks until the session has ended
+func (session *Session) Wait() {
+	<-session.doneChannel
+}
+
+// linkDead is called when the connection drops. The character stays in the
+// world until its user logs back in or the link-dead timeout runs out.
+func (session *Session) linkDead() {
+	fmt.Println("Link-dead:", session.player.GetName())
+	session.linkDeadTimer = time.NewTimer(linkDeadTimeout)
+	model.SetLinkDead(session.player, true)
+
+	release(session.conn)
+}
+
+// releaser is implemented by connections that want to know when their session
+// is done with them
+type releaser interface {
+	Released()
+}
+
+// release tells the connection that the session is done with it, because it
+// dropped or because another connection took over
+func release(conn io.ReadWriter) {
+	if r, ok := conn.(releaser); ok {
+		r.Released()
+	}
+}
+
+// linkDeadTimeout returns a channel that fires when the character has been
+// link-dead for too long, nil if it isn't link-dead
+func (session *Session) linkDeadTimeout() <-chan time.Time {
+	if session.linkDeadTimer == nil {
+		return nil
+	}
+
+	return session.linkDeadTimer.C
+}
+
+func (session *Session) reattach(conn io.ReadWriter) {
+	if session.linkDeadTimer != nil {
+		session.linkDeadTimer.Stop()
+		session.linkDeadTimer = nil
+		model.SetLinkDead(session.player, false)
+	} else {
+		utils.WriteLine(session.conn, "\r\nThis character has been taken over by another connection", utils.ColorModeNone)
+	}
+
+	release(session.conn)
+
+	if closer, ok := session.conn.(io.Closer); ok {
+		closer.Close()
+	}
+
+	session.conn = conn
+
+	if session.user.GetCharMode() {
+		session.setCharMode(true)
+	}
+
+	session.printLineColor(utils.ColorWhite, "Reconnected to "+session.player.GetName())
+	session.printRoom()
+	session.sendVitals()
+	session.sendRoomInfo()
+
+	session.startReader(conn)
+}
+
+func (session *Session) Exec() {
+	defer close(session.doneChannel)
+
+	session.register()
+	defer session.unregister()
+
+	defer model.Unregister(session.eventChannel)
+	defer model.Logout(session.player)
+
+	session.printLineColor(utils.ColorWhite, "Welcome, "+session.player.GetName())
+	session.printRoom()
+	session.sendVitals()
+
+	if session.user.GetCharMode() {
+		session.setCharMode(true)
+	}
+	defer session.setCharMode(false)
+	session.sendRoomInfo()
+
+	session.startReader(session.conn)
+	defer func() {
+		close(session.reader.stop)
+	}()
 
 	// Main loop
 	for {
@@ -151,8 +353,19 @@ func (session *Session) printRoom() {
 		model.GetItems(session.room.GetItemIds()), area))
 }
 
+// setRoom updates the room that the session's character is in, it should be
+// called whenever the character moves
+func (session *Session) setRoom(room *database.Room) {
+	session.room = room
+	session.sendRoomInfo()
+}
+
 func (session *Session) clearLine() {
-	utils.ClearLine(session.conn)
+	if session.user.GetCapabilities().CursorControl() {
+		utils.ClearLine(session.conn)
+	} else {
+		utils.Write(session.conn, "\r\n", utils.ColorModeNone)
+	}
 }
 
 func (session *Session) asyncMessage(message string) {
@@ -185,55 +398,100 @@ func (session *Session) execMenu(menu *utils.Menu) (string, bson.ObjectId) {
 // event loop by using channels and a separate Go routine to grab
 // either the next user input or the next event.
 func (session *Session) getUserInputP(inputMode userInputMode, prompter utils.Prompter) string {
-	session.inputModeChannel <- inputMode
-	session.prompterChannel <- prompter
+	request := inputRequest{mode: inputMode, prompter: prompter}
+	session.reader.requests <- request
 
 	for {
 		select {
-		case input := <-session.userInputChannel:
+		case input := <-session.reader.input:
 			return input
 		case event := <-session.eventChannel:
-			if session.silentMode || !event.IsFor(session.player) {
-				continue
-			}
+			session.handleEvent(event, prompter)
 
-			if event.Type() == model.TellEventType {
-				tellEvent := event.(model.TellEvent)
-				session.replyId = tellEvent.From.GetId()
-			} else if event.Type() == model.CombatEventType {
-				combatEvent := event.(model.CombatEvent)
-
-				if combatEvent.Defender == &session.player.Character {
-					session.player.Hit(combatEvent.Damage)
-					if session.player.GetHitPoints() <= 0 {
-						session.asyncMessage(">> You're dead <<")
-						model.StopFight(combatEvent.Defender)
-						model.StopFight(combatEvent.Attacker)
-					}
-				}
-			} else if event.Type() == model.TimerEventType {
-				if !model.InCombat(&session.player.Character) {
-					oldHps := session.player.GetHitPoints()
-					session.player.Heal(5)
-					newHps := session.player.GetHitPoints()
-
-					if oldHps != newHps {
-						session.clearLine()
-						session.user.Write(prompter.GetPrompt())
-					}
-				}
+		case conn := <-session.disconnectChannel:
+			// A connection that's been replaced going away doesn't matter
+			if conn == session.conn {
+				session.linkDead()
 			}
 
-			message := event.ToString(&session.player.Character)
-			if message != "" {
-				session.asyncMessage(message)
-				session.user.Write(prompter.GetPrompt())
+		case conn := <-session.reattachChannel:
+			session.reattach(conn)
+
+			// The input that was being waited for is now read from the new
+			// connection
+			session.reader.requests <- request
+
+		case <-session.linkDeadTimeout():
+			panic("Link-dead for too long (" + session.player.GetName() + ")")
+		}
+	}
+}
+
+// handleEvent reacts to an event from the model. Kicks, jail moves, combat and
+// healing change the state of the game and are always handled, silent mode
+// only hides what other players are saying and doing.
+func (session *Session) handleEvent(event model.Event, prompter utils.Prompter) {
+	if !event.IsFor(session.player) {
+		return
+	}
+
+	switch event.Type() {
+	case model.KickEventType:
+		session.asyncMessage(event.ToString(&session.player.Character))
+		panic("Kicked out of the game (" + session.player.GetName() + ")")
+
+	case model.JailEventType:
+		// The character was taken in or out of jail
+		session.setRoom(event.(model.JailEvent).Room)
+		session.asyncMessage(event.ToString(&session.player.Character))
+		session.printRoom()
+		session.user.WritePrompt(prompter.GetPrompt())
+		return
+
+	case model.CombatEventType:
+		combatEvent := event.(model.CombatEvent)
+
+		if combatEvent.Defender == &session.player.Character {
+			session.player.Hit(combatEvent.Damage)
+			session.sendVitals()
+			if session.player.GetHitPoints() <= 0 {
+				session.asyncMessage(">> You're dead <<")
+				model.StopFight(combatEvent.Defender)
+				model.StopFight(combatEvent.Attacker)
 			}
+		}
+
+	case model.TimerEventType:
+		if !model.InCombat(&session.player.Character) {
+			oldHps := session.player.GetHitPoints()
+			session.player.Heal(regenAmount)
+			newHps := session.player.GetHitPoints()
 
-		case quitMessage := <-session.panicChannel:
-			panic(quitMessage)
+			if oldHps != newHps {
+				session.sendVitals()
+				session.clearLine()
+				session.user.WritePrompt(prompter.GetPrompt())
+			}
 		}
 	}
+
+	if session.silentMode {
+		return
+	}
+
+	if event.Type() == model.TellEventType {
+		tellEvent := event.(model.TellEvent)
+		session.replyId = tellEvent.From.GetId()
+		session.sendChannelText(event)
+	} else if event.Type() == model.SayEventType || event.Type() == model.BroadcastEventType {
+		session.sendChannelText(event)
+	}
+
+	message := event.ToString(&session.player.Character)
+	if message != "" {
+		session.asyncMessage(message)
+		session.user.WritePrompt(prompter.GetPrompt())
+	}
 }
 
 func (session *Session) getUserInput(inputMode userInputMode, prompt string) string {
@@ -248,6 +506,103 @@ func (session *Session) GetPrompt() string {
 	return utils.Colorize(utils.ColorWhite, prompt)
 }
 
+// optionChecker is implemented by connections that negotiate telnet options
+type optionChecker interface {
+	Enabled(option telnet.TelnetCode) bool
+	NegotiatesOptions() bool
+}
+
+// optionEnabled returns true if the client has agreed to the given telnet
+// option, it's always false for connections that don't negotiate options
+func (session *Session) optionEnabled(option telnet.TelnetCode) bool {
+	if checker, ok := session.conn.(optionChecker); ok {
+		return checker.Enabled(option)
+	}
+
+	return false
+}
+
+// negotiatesOptions returns true if the connection negotiates telnet options
+// at all, so that a missing option means the client declined it
+func (session *Session) negotiatesOptions() bool {
+	checker, ok := session.conn.(optionChecker)
+	return ok && checker.NegotiatesOptions()
+}
+
+// secureChecker is implemented by connections that know whether they're
+// encrypted
+type secureChecker interface {
+	Secure() bool
+}
+
+// secure returns true if the session's connection is encrypted (TLS or SSH)
+func (session *Session) secure() bool {
+	if checker, ok := session.conn.(secureChecker); ok {
+		return checker.Secure()
+	}
+
+	return false
+}
+
+// charModer is implemented by connections that can be switched into character
+// mode, where input is read through a line editor
+type charModer interface {
+	SetCharMode(enabled bool)
+	LineEditor() *utils.LineEditor
+}
+
+// setCharMode switches the connection into or out of character mode, returning
+// false if the connection doesn't support it
+func (session *Session) setCharMode(enabled bool) bool {
+	moder, ok := session.conn.(charModer)
+
+	if !ok {
+		return false
+	}
+
+	moder.SetCharMode(enabled)
+
+	if editor := moder.LineEditor(); editor != nil {
+		editor.Completer = session.complete
+	}
+
+	return true
+}
+
+// complete returns the words that can be tab completed at the end of the given
+// line: command and action names for the first word, the names of what's in
+// the room (and of online players, for commands) after that
+func (session *Session) complete(line string) []string {
+	var words []string
+
+	fields := strings.Fields(line)
+
+	if len(fields) == 0 {
+		for _, name := range utils.MethodNames(&session.commander) {
+			if session.commander.allowed(name) {
+				words = append(words, "/"+name)
+			}
+		}
+
+		words = append(words, utils.MethodNames(&session.actioner)...)
+		return words
+	}
+
+	words = append(words, model.PlayerCharactersIn(session.room, session.player).Characters().Names()...)
+	words = append(words, model.NpcsIn(session.room).Characters().Names()...)
+	words = append(words, database.ItemNames(model.GetItems(session.room.GetItemIds()))...)
+
+	if strings.HasPrefix(fields[0], "/") {
+		for _, pc := range model.GetOnlinePlayerCharacters() {
+			if pc != session.player {
+				words = append(words, pc.GetName())
+			}
+		}
+	}
+
+	return words
+}
+
 func (session *Session) currentZone() *database.Zone {
 	return model.GetZone(session.room.GetZoneId())
 }
@@ -1,8 +1,17 @@
 package session
 
 import (
+	"github.com/Cristofori/kmud/database"
+	"github.com/Cristofori/kmud/database/dbtest"
+	"github.com/Cristofori/kmud/model"
+	"github.com/Cristofori/kmud/utils"
+	"io"
+	"io/ioutil"
+	"net"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 // import "fmt"
@@ -61,4 +70,97 @@ func Test_UserInputHandlers(t *testing.T) {
 	checkMethods(&ch, t)
 }
 
+// Every command that isn't meant for all players has to be listed with the
+// permission it needs, otherwise anyone can use it
+func Test_CommandPermissions(t *testing.T) {
+	playerCommands := map[string]bool{
+		"loc": true, "location": true, "map": true, "b": true, "broadcast": true,
+		"s": true, "say": true, "me": true, "w": true, "tell": true, "whisper": true,
+		"r": true, "who": true, "colors": true, "cm": true, "colormode": true,
+		"charmode": true, "sshkey": true, "ws": true, "tt": true, "silent": true,
+	}
+
+	objType := reflect.TypeOf(&commandHandler{})
+	commands := map[string]bool{}
+
+	for i := 0; i < objType.NumMethod(); i++ {
+		method := objType.Method(i)
+
+		if method.PkgPath != "" {
+			continue
+		}
+
+		command := strings.ToLower(method.Name)
+		commands[command] = true
+
+		_, restricted := commandPermissions[command]
+
+		if !restricted && !playerCommands[command] {
+			t.Errorf("Command %s has no permission, and isn't a player command", command)
+		}
+
+		if restricted && playerCommands[command] {
+			t.Errorf("Player command %s needs a permission", command)
+		}
+	}
+
+	for command := range commandPermissions {
+		if !commands[command] {
+			t.Errorf("Permission given for %s, which isn't a command", command)
+		}
+	}
+}
+
+// Silent mode hides chatter, but mustn't keep a banned user in the game
+func Test_SilentBan(t *testing.T) {
+	model.Init(dbtest.TestSession{}, "unit_session_test")
+
+	user := model.CreateUser("Silent", "password")
+	pc := database.NewPlayerChar("Silent", user.GetId(), "")
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go io.Copy(ioutil.Discard, client)
+	user.SetConnection(server)
+	user.SetOnline(true)
+
+	var session Session
+	session.conn = server
+	session.user = user
+	session.player = pc
+	session.eventChannel = model.Register()
+	defer model.Unregister(session.eventChannel)
+
+	session.commander.session = &session
+	session.commander.Silent([]string{"on"})
+
+	model.Impose(user, database.SanctionBan, database.Sanction{Reason: "test"})
+
+	if _, err := server.Write([]byte("still here")); err == nil {
+		t.Errorf("The banned user's connection wasn't closed")
+	}
+
+	kicked := func(event model.Event) (kicked bool) {
+		defer func() {
+			kicked = recover() != nil
+		}()
+
+		session.handleEvent(event, utils.SimplePrompter("> "))
+		return false
+	}
+
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case event := <-session.eventChannel:
+			if kicked(event) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("A silent session wasn't kicked when its user was banned")
+		}
+	}
+}
+
 // vim:nocindent
@@ -0,0 +1,152 @@
+package telnet
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// Character set negotiation, RFC 2066: http://tools.ietf.org/html/rfc2066
+//
+// Everything above the telnet layer works with UTF-8. When a client settles
+// on Latin-1 instead, its input is converted to UTF-8 as it's read and output
+// is converted back to Latin-1 as it's written.
+
+const (
+	charsetRequest  = '\x01'
+	charsetAccepted = '\x02'
+	charsetRejected = '\x03'
+)
+
+const (
+	CharsetUTF8   = "UTF-8"
+	CharsetLatin1 = "ISO-8859-1"
+)
+
+// Supported character sets, in order of preference
+var supportedCharsets = []string{CharsetUTF8, CharsetLatin1}
+
+// normalizeCharset maps the various names clients use for a character set on
+// to the ones we support, an empty string is returned for anything else
+func normalizeCharset(name string) string {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	name = strings.Replace(name, "_", "-", -1)
+
+	switch name {
+	case "UTF-8", "UTF8":
+		return CharsetUTF8
+	case "ISO-8859-1", "ISO8859-1", "LATIN1", "LATIN-1":
+		return CharsetLatin1
+	}
+
+	return ""
+}
+
+// WillCharset offers to negotiate the character set used on the connection
+func (t *Telnet) WillCharset() {
+	t.EnableLocal(CHARSET)
+}
+
+// Charset returns the character set that has been agreed to with the client,
+// or an empty string if none has been
+func (t *Telnet) Charset() string {
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+
+	return t.charset
+}
+
+func (t *Telnet) setCharset(charset string) {
+	t.writeMutex.Lock()
+	t.charset = charset
+	t.writeMutex.Unlock()
+
+	t.processor.latin1 = charset == CharsetLatin1
+}
+
+func (t *Telnet) requestCharset() {
+	request := []byte{charsetRequest}
+	for _, charset := range supportedCharsets {
+		request = append(request, ';')
+		request = append(request, charset...)
+	}
+
+	command := BuildCommand(SB, CHARSET)
+	command = append(command, request...)
+	command = append(command, BuildCommand(SE)...)
+	t.send(command)
+}
+
+// receiveCharset handles a CHARSET subnegotiation from the client, which is
+// either the answer to our request or a request of its own
+func (t *Telnet) receiveCharset(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	switch data[0] {
+	case charsetAccepted:
+		if charset := normalizeCharset(string(data[1:])); charset != "" {
+			t.setCharset(charset)
+		}
+
+	case charsetRequest:
+		data = data[1:]
+
+		// Translation tables aren't supported, skip over the version number
+		if bytes.HasPrefix(data, []byte("[TTABLE]")) && len(data) > 9 {
+			data = data[9:]
+		}
+
+		if len(data) < 2 {
+			t.sendCharsetReply(charsetRejected, "")
+			return
+		}
+
+		// The first byte of the list is the separator that the client chose
+		offered := strings.Split(string(data[1:]), string(data[:1]))
+
+		for _, name := range offered {
+			if charset := normalizeCharset(name); charset != "" {
+				t.sendCharsetReply(charsetAccepted, name)
+				t.setCharset(charset)
+				return
+			}
+		}
+
+		t.sendCharsetReply(charsetRejected, "")
+	}
+}
+
+func (t *Telnet) sendCharsetReply(reply byte, name string) {
+	command := BuildCommand(SB, CHARSET)
+	command = append(command, reply)
+	command = append(command, name...)
+	command = append(command, BuildCommand(SE)...)
+	t.send(command)
+}
+
+// encodeLatin1 converts UTF-8 text to Latin-1, doubling any IAC bytes that
+// result. Characters that Latin-1 can't represent are replaced with '?'.
+func encodeLatin1(p []byte) []byte {
+	encoded := make([]byte, 0, len(p))
+
+	for len(p) > 0 {
+		r, size := utf8.DecodeRune(p)
+		p = p[size:]
+
+		if r > 0xFF {
+			r = '?'
+		}
+
+		encoded = append(encoded, byte(r))
+
+		if byte(r) == codeToByte[IAC] {
+			encoded = append(encoded, byte(r))
+		}
+	}
+
+	return encoded
+}
+
+// vim: nocindent
@@ -0,0 +1,288 @@
+package telnet
+
+// Option negotiation following the "Q method" described in RFC 1143:
+// http://tools.ietf.org/html/rfc1143
+//
+// Each option is tracked twice, once for our side of the connection (WILL/WONT
+// sent by us, DO/DONT received) and once for the client's side (DO/DONT sent by
+// us, WILL/WONT received). Tracking the requests that are in flight is what
+// keeps both ends from looping forever when they disagree.
+
+type qState int
+
+const (
+	qNo      qState = iota // Option is disabled
+	qYes     qState = iota // Option is enabled
+	qWantNo  qState = iota // We've asked to disable the option and are waiting for a reply
+	qWantYes qState = iota // We've asked to enable the option and are waiting for a reply
+)
+
+type qQueue int
+
+const (
+	qEmpty    qQueue = iota // No change is queued
+	qOpposite qQueue = iota // The opposite of the pending request should be made once it's answered
+)
+
+type qReply int
+
+const (
+	replyNone    qReply = iota
+	replyEnable  qReply = iota // DO or WILL, depending on the side
+	replyDisable qReply = iota // DONT or WONT, depending on the side
+)
+
+// qSide holds the negotiation state of one side of a single option
+type qSide struct {
+	state qState
+	queue qQueue
+}
+
+func (self *qSide) enabled() bool {
+	return self.state == qYes
+}
+
+// received processes an enable (WILL/DO) or disable (WONT/DONT) message from
+// the other end. accept decides whether an unsolicited request to enable the
+// option is agreed to. The reply that has to be sent back is returned.
+func (self *qSide) received(enable bool, accept bool) qReply {
+	if enable {
+		switch self.state {
+		case qNo:
+			if accept {
+				self.state = qYes
+				return replyEnable
+			}
+			return replyDisable
+		case qYes:
+			// Already enabled, ignore
+		case qWantNo:
+			// The other end answered a disable request with an enable, that's
+			// an error on their part, treat it as a refusal to disable
+			if self.queue == qEmpty {
+				self.state = qNo
+			} else {
+				self.state = qYes
+				self.queue = qEmpty
+			}
+		case qWantYes:
+			if self.queue == qEmpty {
+				self.state = qYes
+			} else {
+				self.state = qWantNo
+				self.queue = qEmpty
+				return replyDisable
+			}
+		}
+	} else {
+		switch self.state {
+		case qNo:
+			// Already disabled, ignore
+		case qYes:
+			self.state = qNo
+			return replyDisable
+		case qWantNo:
+			if self.queue == qEmpty {
+				self.state = qNo
+			} else {
+				self.state = qWantYes
+				self.queue = qEmpty
+				return replyEnable
+			}
+		case qWantYes:
+			self.state = qNo
+			self.queue = qEmpty
+		}
+	}
+
+	return replyNone
+}
+
+// request asks for the option to be enabled or disabled, returning the message
+// that has to be sent to the other end (if any)
+func (self *qSide) request(enable bool) qReply {
+	if enable {
+		switch self.state {
+		case qNo:
+			self.state = qWantYes
+			return replyEnable
+		case qWantNo:
+			self.queue = qOpposite
+		case qWantYes:
+			self.queue = qEmpty
+		}
+	} else {
+		switch self.state {
+		case qYes:
+			self.state = qWantNo
+			return replyDisable
+		case qWantNo:
+			self.queue = qEmpty
+		case qWantYes:
+			self.queue = qOpposite
+		}
+	}
+
+	return replyNone
+}
+
+// qOption holds the negotiation state of both sides of a single option
+type qOption struct {
+	us  qSide
+	him qSide
+}
+
+// Options that we're willing to perform when the client asks us to, without
+// having offered them first
+var localOptions = map[TelnetCode]bool{
+	SGA:     true,
+	CMP2:    true,
+	GMCP:    true,
+	MSSP:    true,
+	CHARSET: true,
+	EOR:     true,
+	MXP:     true,
+}
+
+// Options that we'll let the client perform when it offers them, without
+// having asked for them first
+var remoteOptions = map[TelnetCode]bool{
+	WS:      true,
+	TT:      true,
+	CHARSET: true,
+}
+
+func (t *Telnet) option(option byte) *qOption {
+	if t.options == nil {
+		t.options = map[byte]*qOption{}
+	}
+
+	opt, found := t.options[option]
+
+	if !found {
+		opt = &qOption{}
+		t.options[option] = opt
+	}
+
+	return opt
+}
+
+// receiveCommand runs a WILL/WONT/DO/DONT received from the client through the
+// state machine, replying to it and reacting to any resulting change in state
+func (t *Telnet) receiveCommand(command TelnetCode, option byte) {
+	code, known := byteToCode[option]
+
+	t.optionMutex.Lock()
+	opt := t.option(option)
+
+	local := command == DO || command == DONT
+	enable := command == DO || command == WILL
+
+	var side *qSide
+	var accept bool
+
+	if local {
+		side = &opt.us
+		accept = known && localOptions[code]
+	} else {
+		side = &opt.him
+		accept = known && remoteOptions[code]
+	}
+
+	before := side.enabled()
+	reply := side.received(enable, accept)
+	after := side.enabled()
+	t.optionMutex.Unlock()
+
+	t.sendReply(reply, local, option)
+
+	if known && before != after {
+		t.optionChanged(code, local, after)
+	}
+}
+
+// requestOption asks the client to enable/disable an option on our side
+// (local) or on theirs
+func (t *Telnet) requestOption(option TelnetCode, local bool, enable bool) {
+	t.optionMutex.Lock()
+	opt := t.option(codeToByte[option])
+
+	side := &opt.him
+	if local {
+		side = &opt.us
+	}
+
+	reply := side.request(enable)
+	t.optionMutex.Unlock()
+
+	t.sendReply(reply, local, codeToByte[option])
+}
+
+func (t *Telnet) sendReply(reply qReply, local bool, option byte) {
+	var command TelnetCode
+
+	switch reply {
+	case replyNone:
+		return
+	case replyEnable:
+		command = DO
+		if local {
+			command = WILL
+		}
+	case replyDisable:
+		command = DONT
+		if local {
+			command = WONT
+		}
+	}
+
+	// The option byte is written directly so that options without a TelnetCode
+	// can still be refused
+	t.send([]byte{codeToByte[IAC], codeToByte[command], option})
+}
+
+// EnableLocal offers to perform the given option (IAC WILL)
+func (t *Telnet) EnableLocal(option TelnetCode) {
+	t.requestOption(option, true, true)
+}
+
+// DisableLocal stops performing the given option (IAC WONT)
+func (t *Telnet) DisableLocal(option TelnetCode) {
+	t.requestOption(option, true, false)
+}
+
+// EnableRemote asks the client to perform the given option (IAC DO)
+func (t *Telnet) EnableRemote(option TelnetCode) {
+	t.requestOption(option, false, true)
+}
+
+// DisableRemote asks the client to stop performing the given option (IAC DONT)
+func (t *Telnet) DisableRemote(option TelnetCode) {
+	t.requestOption(option, false, false)
+}
+
+// LocalEnabled returns true if both ends have agreed that we are performing
+// the given option
+func (t *Telnet) LocalEnabled(option TelnetCode) bool {
+	t.optionMutex.Lock()
+	defer t.optionMutex.Unlock()
+
+	return t.option(codeToByte[option]).us.enabled()
+}
+
+// RemoteEnabled returns true if both ends have agreed that the client is
+// performing the given option
+func (t *Telnet) RemoteEnabled(option TelnetCode) bool {
+	t.optionMutex.Lock()
+	defer t.optionMutex.Unlock()
+
+	return t.option(codeToByte[option]).him.enabled()
+}
+
+// Enabled returns true if the given option has been agreed to on either side
+// of the connection
+func (t *Telnet) Enabled(option TelnetCode) bool {
+	return t.LocalEnabled(option) || t.RemoteEnabled(option)
+}
+
+// vim: nocindent
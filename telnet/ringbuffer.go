@@ -0,0 +1,88 @@
+package telnet
+
+// ringBuffer is a FIFO byte queue backed by a single slice that's reused as
+// data is written to and read from it. It only grows when more data is
+// written than has been read, so a connection that's drained as fast as it's
+// filled never allocates again.
+type ringBuffer struct {
+	data   []byte
+	start  int
+	length int
+}
+
+func newRingBuffer(size int) ringBuffer {
+	return ringBuffer{data: make([]byte, size)}
+}
+
+// Len returns the number of unread bytes in the buffer
+func (self *ringBuffer) Len() int {
+	return self.length
+}
+
+// grow makes room for at least n more bytes, unwrapping the unread data to the
+// start of the new slice
+func (self *ringBuffer) grow(n int) {
+	if self.length+n <= len(self.data) {
+		return
+	}
+
+	size := len(self.data) * 2
+	if size < self.length+n {
+		size = self.length + n
+	}
+
+	data := make([]byte, size)
+	length, _ := self.Read(data)
+
+	self.data = data
+	self.start = 0
+	self.length = length
+}
+
+func (self *ringBuffer) Write(p []byte) (int, error) {
+	self.grow(len(p))
+
+	end := (self.start + self.length) % len(self.data)
+	n := copy(self.data[end:], p)
+	copy(self.data, p[n:])
+
+	self.length += len(p)
+	return len(p), nil
+}
+
+func (self *ringBuffer) WriteByte(b byte) error {
+	self.grow(1)
+
+	self.data[(self.start+self.length)%len(self.data)] = b
+	self.length++
+	return nil
+}
+
+// Read copies as much unread data as fits in p. Zero is returned without an
+// error when the buffer is empty, it's up to the owner of the buffer to decide
+// when that means the end of the stream.
+func (self *ringBuffer) Read(p []byte) (int, error) {
+	n := 0
+
+	for n < len(p) && self.length > 0 {
+		end := self.start + self.length
+		if end > len(self.data) {
+			end = len(self.data)
+		}
+
+		copied := copy(p[n:], self.data[self.start:end])
+		n += copied
+
+		self.start = (self.start + copied) % len(self.data)
+		self.length -= copied
+	}
+
+	if self.length == 0 {
+		// Keep the data contiguous for as long as possible
+		self.start = 0
+	}
+
+	return n, nil
+}
+
+// vim: nocindent
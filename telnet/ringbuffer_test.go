@@ -0,0 +1,50 @@
+package telnet
+
+import (
+	"testing"
+)
+
+func Test_RingBuffer(t *testing.T) {
+	buffer := newRingBuffer(8)
+	readBuffer := make([]byte, 16)
+
+	buffer.Write([]byte("abcdef"))
+	n, _ := buffer.Read(readBuffer[:4])
+
+	if string(readBuffer[:n]) != "abcd" || buffer.Len() != 2 {
+		t.Errorf("Read() == %q with %v left, want %q with 2 left", readBuffer[:n], buffer.Len(), "abcd")
+	}
+
+	// Wraps around the end of the slice without growing
+	buffer.Write([]byte("ghijk"))
+	buffer.WriteByte('l')
+
+	if len(buffer.data) != 8 || buffer.Len() != 8 {
+		t.Errorf("Buffer grew to %v bytes holding %v, want 8 holding 8", len(buffer.data), buffer.Len())
+	}
+
+	n, _ = buffer.Read(readBuffer)
+
+	if string(readBuffer[:n]) != "efghijkl" {
+		t.Errorf("Read() == %q, want %q", readBuffer[:n], "efghijkl")
+	}
+
+	// Grows when more is written than has been read, keeping the order
+	buffer.Write([]byte("mnopqr"))
+	buffer.Read(readBuffer[:3])
+	buffer.Write([]byte("stuvwxyz"))
+
+	n, _ = buffer.Read(readBuffer)
+
+	if string(readBuffer[:n]) != "pqrstuvwxyz" {
+		t.Errorf("Read() == %q, want %q", readBuffer[:n], "pqrstuvwxyz")
+	}
+
+	n, err := buffer.Read(readBuffer)
+
+	if n != 0 || err != nil || buffer.Len() != 0 {
+		t.Errorf("Read() from an empty buffer == %v, %v", n, err)
+	}
+}
+
+// vim: nocindent
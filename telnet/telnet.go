@@ -1,14 +1,23 @@
 package telnet
 
 import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 // RFC 854: http://tools.ietf.org/html/rfc854, http://support.microsoft.com/kb/231866
 
+// Number of bytes read from the connection at a time
+const readBufferSize = 4096
+
 var byteToCode map[byte]TelnetCode
 var codeToByte map[TelnetCode]byte
 
@@ -22,47 +31,137 @@ type Telnet struct {
 	conn net.Conn
 	err  error
 
-	processor telnetProcessor
+	processor  telnetProcessor
+	readBuffer []byte
+
+	writeMutex sync.Mutex
+	compressor *zlib.Writer
+
+	optionMutex sync.Mutex
+	options     map[byte]*qOption
+
+	msspStatus func() map[string]string
+
+	ttMutex       sync.Mutex
+	terminalTypes []string
+
+	charset string
+
+	listenFunc func(TelnetCode, []byte)
 }
 
 func NewTelnet(conn net.Conn) *Telnet {
 	var t Telnet
 	t.conn = conn
 	t.processor = newTelnetProcessor()
+	t.readBuffer = make([]byte, readBufferSize)
+	t.processor.commandFunc = t.receiveCommand
+	t.processor.listenFunc = t.receiveSubData
 	return &t
 }
 
+// Write sends UTF-8 text to the client, converting it to the client's
+// character set if necessary
 func (t *Telnet) Write(p []byte) (int, error) {
-	return t.conn.Write(p)
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+
+	if t.charset == CharsetLatin1 {
+		_, err := t.write(encodeLatin1(p))
+
+		if err != nil {
+			return 0, err
+		}
+
+		return len(p), nil
+	}
+
+	return t.write(p)
+}
+
+// send writes telnet commands and subnegotiations, which must not go through
+// any character set conversion
+func (t *Telnet) send(p []byte) (int, error) {
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+
+	return t.write(p)
+}
+
+// write sends the data through the compression stream if one has been
+// negotiated, otherwise straight to the connection. The caller must hold
+// writeMutex.
+func (t *Telnet) write(p []byte) (int, error) {
+	if t.compressor == nil {
+		return t.conn.Write(p)
+	}
+
+	n, err := t.compressor.Write(p)
+
+	if err != nil {
+		return n, err
+	}
+
+	return n, t.compressor.Flush()
 }
 
+// Read returns the client's input with all telnet codes removed. Input that
+// has already been processed is returned before the connection is read from
+// again, and an error from the connection (e.g. io.EOF) is only returned once
+// everything before it has been read.
 func (t *Telnet) Read(p []byte) (int, error) {
-	t.fill()
-	if t.err != nil {
-		return 0, t.err
+	for t.processor.Len() == 0 && t.err == nil {
+		if n := t.fill(); n == 0 {
+			break
+		}
+	}
+
+	if t.processor.Len() > 0 {
+		return t.processor.Read(p)
 	}
 
-	n, e := t.processor.Read(p)
-	return n, e
+	// The error isn't kept around after it's been returned, so that reading
+	// can carry on after recoverable ones such as timeouts
+	err := t.err
+	t.err = nil
+	return 0, err
 }
 
 func (t *Telnet) Data(code TelnetCode) []byte {
 	return t.processor.subdata[code]
 }
 
+// Listen registers a function that is called with the data of every completed
+// subnegotiation. Terminal types are only reported once the client has
+// finished cycling through them, see TerminalTypes().
 func (t *Telnet) Listen(listenFunc func(TelnetCode, []byte)) {
-	t.processor.listenFunc = listenFunc
+	t.listenFunc = listenFunc
+}
+
+func (t *Telnet) receiveSubData(code TelnetCode, data []byte) {
+	if code == TT && !t.receiveTerminalType(data) {
+		return
+	}
+
+	if code == CHARSET {
+		t.receiveCharset(data)
+	}
+
+	if t.listenFunc != nil {
+		t.listenFunc(code, data)
+	}
 }
 
 // Idea/name for this function shamelessly stolen from bufio
-func (t *Telnet) fill() {
-	buf := make([]byte, 1024)
-	n, err := t.conn.Read(buf)
+func (t *Telnet) fill() int {
+	n, err := t.conn.Read(t.readBuffer)
 	t.err = err
-	t.processor.addBytes(buf[:n])
+	t.processor.addBytes(t.readBuffer[:n])
+	return n
 }
 
 func (t *Telnet) Close() error {
+	t.stopCompression()
 	return t.conn.Close()
 }
 
@@ -87,33 +186,274 @@ func (t *Telnet) SetWriteDeadline(dl time.Time) error {
 }
 
 func (t *Telnet) WillEcho() {
-	t.SendCommand(WILL, ECHO)
+	t.EnableLocal(ECHO)
 }
 
 func (t *Telnet) WontEcho() {
-	t.SendCommand(WONT, ECHO)
+	t.DisableLocal(ECHO)
+}
+
+// SetCharMode switches the client between sending its input a character at a
+// time and a line at a time. Character mode is entered by offering to echo
+// and to suppress go ahead, leaving all echoing and line editing to us.
+func (t *Telnet) SetCharMode(enabled bool) {
+	if enabled {
+		t.EnableLocal(SGA)
+		t.EnableLocal(ECHO)
+	} else {
+		t.DisableLocal(ECHO)
+		t.DisableLocal(SGA)
+	}
+}
+
+// CharMode returns true if the client has agreed to character mode
+func (t *Telnet) CharMode() bool {
+	return t.LocalEnabled(SGA) && t.LocalEnabled(ECHO)
 }
 
 func (t *Telnet) DoWindowSize() {
-	t.SendCommand(DO, WS)
+	t.EnableRemote(WS)
 }
 
+// DoTerminalType asks the client to report its terminal type. The request for
+// the actual value is sent once the client has agreed to the option.
+// See http://tools.ietf.org/html/rfc1091
 func (t *Telnet) DoTerminalType() {
-	// This is really supposed to be two commands, one to ask if they'll send a
-	// terminal type, and another to indicate that they should send it if
-	// they've expressed a "willingness" to send it. For the time being this
-	// works well enough.
+	t.EnableRemote(TT)
+}
+
+// WillCompress offers MCCP2 compression to the client. Compression starts once
+// the client answers with IAC DO MCCP2.
+// See http://tintin.sourceforge.net/mccp/
+func (t *Telnet) WillCompress() {
+	t.EnableLocal(CMP2)
+}
+
+// Compressed returns true if the outgoing stream is currently being compressed
+func (t *Telnet) Compressed() bool {
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+
+	return t.compressor != nil
+}
+
+func (t *Telnet) startCompression() {
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+
+	if t.compressor != nil {
+		return
+	}
+
+	// The subnegotiation itself is the last thing sent uncompressed, everything
+	// after it is part of the zlib stream
+	t.conn.Write(BuildCommand(SB, CMP2, IAC, SE))
+	t.compressor = zlib.NewWriter(t.conn)
+}
+
+func (t *Telnet) stopCompression() {
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+
+	if t.compressor != nil {
+		t.compressor.Close()
+		t.compressor = nil
+	}
+}
+
+// WillGMCP offers the Generic Mud Communication Protocol to the client.
+// See http://www.gammon.com.au/gmcp
+func (t *Telnet) WillGMCP() {
+	t.EnableLocal(GMCP)
+}
+
+// GMCPEnabled returns true if the client has agreed to receive GMCP messages
+func (t *Telnet) GMCPEnabled() bool {
+	return t.LocalEnabled(GMCP)
+}
 
-	// See http://tools.ietf.org/html/rfc884
+// SendGMCP sends a GMCP message for the given package/message name (e.g.
+// "Char.Vitals"). The data is encoded as JSON, a nil value sends the message
+// with no payload. Nothing is sent if the client hasn't enabled GMCP.
+func (t *Telnet) SendGMCP(module string, data interface{}) error {
+	if !t.GMCPEnabled() {
+		return nil
+	}
+
+	payload := []byte(module)
+
+	if data != nil {
+		encoded, err := json.Marshal(data)
+
+		if err != nil {
+			return err
+		}
+
+		payload = append(payload, ' ')
+		payload = append(payload, encoded...)
+	}
+
+	command := BuildCommand(SB, GMCP)
+	command = append(command, escapeIAC(payload)...)
+	command = append(command, BuildCommand(SE)...)
+
+	_, err := t.send(command)
+	return err
+}
+
+// ParseGMCP splits the subnegotiation data of a GMCP message into its
+// package/message name and its (still JSON encoded) payload
+func ParseGMCP(data []byte) (string, []byte) {
+	data = bytes.TrimSpace(data)
+	index := bytes.IndexAny(data, " \t\r\n")
+
+	if index == -1 {
+		return string(data), nil
+	}
+
+	return string(data[:index]), bytes.TrimSpace(data[index+1:])
+}
+
+// DecodeGMCP parses the subnegotiation data of a GMCP message and unmarshals
+// its JSON payload into v. The package/message name is returned.
+func DecodeGMCP(data []byte, v interface{}) (string, error) {
+	module, payload := ParseGMCP(data)
+
+	if len(payload) == 0 {
+		return module, nil
+	}
+
+	return module, json.Unmarshal(payload, v)
+}
 
-	t.SendCommand(DO, TT, IAC, SB, TT, 1, IAC, SE) // 1 = SEND
+// WillMXP offers the MUD eXtension Protocol to the client
+func (t *Telnet) WillMXP() {
+	t.EnableLocal(MXP)
+}
+
+// MXPEnabled returns true if the client has agreed to MXP
+func (t *Telnet) MXPEnabled() bool {
+	return t.LocalEnabled(MXP)
+}
+
+// WillEOR offers to mark the end of every prompt with IAC EOR, see MarkPrompt
+func (t *Telnet) WillEOR() {
+	t.EnableLocal(EOR)
+}
+
+// MarkPrompt tells the client that the text sent since the last line break
+// is a prompt, so that it can be told apart from a partial line. IAC EOR is
+// used if the client agreed to it, otherwise IAC GA, unless go ahead has been
+// suppressed.
+func (t *Telnet) MarkPrompt() {
+	if t.LocalEnabled(EOR) {
+		t.SendCommand(EORC)
+	} else if !t.LocalEnabled(SGA) {
+		t.SendCommand(GA)
+	}
+}
+
+// Ping sends a no-op to the client, which is ignored by the other end but
+// makes a write error show up if the connection has died
+func (t *Telnet) Ping() error {
+	_, err := t.send(BuildCommand(NOP))
+	return err
+}
+
+// WillMSSP offers the Mud Server Status Protocol to the client. The given
+// function is called to collect the server's current status variables (e.g.
+// NAME, PLAYERS, UPTIME) whenever the client asks for them.
+func (t *Telnet) WillMSSP(status func() map[string]string) {
+	t.msspStatus = status
+	t.EnableLocal(MSSP)
+}
+
+// BuildMSSP encodes the given status variables as an MSSP subnegotiation
+func BuildMSSP(status map[string]string) []byte {
+	const MsspVar = '\x01'
+	const MsspVal = '\x02'
+
+	var names []string
+	for name := range status {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var payload []byte
+	for _, name := range names {
+		payload = append(payload, MsspVar)
+		payload = append(payload, name...)
+		payload = append(payload, MsspVal)
+		payload = append(payload, status[name]...)
+	}
+
+	command := BuildCommand(SB, MSSP)
+	command = append(command, escapeIAC(payload)...)
+	return append(command, BuildCommand(SE)...)
+}
+
+// escapeIAC doubles any IAC bytes in the given data so that it can be sent as
+// part of a subnegotiation
+func escapeIAC(data []byte) []byte {
+	iac := codeToByte[IAC]
+
+	if bytes.IndexByte(data, iac) == -1 {
+		return data
+	}
+
+	escaped := make([]byte, 0, len(data)+1)
+	for _, b := range data {
+		escaped = append(escaped, b)
+		if b == iac {
+			escaped = append(escaped, iac)
+		}
+	}
+
+	return escaped
+}
+
+// optionChanged is called whenever negotiation enables or disables an option,
+// local is true for options performed by us rather than by the client
+func (t *Telnet) optionChanged(option TelnetCode, local bool, enabled bool) {
+	switch option {
+	case CMP2:
+		if !local {
+			return
+		}
+
+		if enabled {
+			t.startCompression()
+		} else {
+			t.stopCompression()
+		}
+	case TT:
+		if !local && enabled {
+			t.SendCommand(SB, TT, 1, IAC, SE) // 1 = SEND
+		}
+	case CHARSET:
+		if local && enabled {
+			t.requestCharset()
+		}
+
+	case MXP:
+		// The client starts parsing MXP once it has been told to
+		if local && enabled {
+			t.SendCommand(SB, MXP, IAC, SE)
+		}
+	case MSSP:
+		if local && enabled && t.msspStatus != nil {
+			t.send(BuildMSSP(t.msspStatus()))
+		}
+	}
 }
 
 func (t *Telnet) SendCommand(codes ...TelnetCode) {
-	t.conn.Write(BuildCommand(codes...))
+	t.send(BuildCommand(codes...))
 }
 
 func BuildCommand(codes ...TelnetCode) []byte {
+	initLookups()
+
 	command := make([]byte, len(codes)+1)
 	command[0] = codeToByte[IAC]
 
@@ -165,6 +505,12 @@ const (
 	AARD TelnetCode = iota // Aardwolf MUD out of band communication, http://www.aardwolf.com/blog/2008/07/10/telnet-negotiation-control-mud-client-interaction/
 	ATCP TelnetCode = iota // Achaea Telnet Client Protocol, http://www.ironrealms.com/rapture/manual/files/FeatATCP-txt.html
 	GMCP TelnetCode = iota // Generic Mud Communication Protocol
+	MSSP TelnetCode = iota // Mud Server Status Protocol, http://tintin.sourceforge.net/mssp/
+
+	CHARSET TelnetCode = iota // Character set negotiation, http://tools.ietf.org/html/rfc2066
+	MXP     TelnetCode = iota // MUD eXtension Protocol, http://www.zuggsoft.com/zmud/mxp.htm
+	EOR     TelnetCode = iota // End of record option, http://tools.ietf.org/html/rfc885
+	EORC    TelnetCode = iota // End of record marker, sent after prompts once EOR is negotiated
 )
 
 func initLookups() {
@@ -214,6 +560,12 @@ func initLookups() {
 	codeToByte[AARD] = '\x66'
 	codeToByte[ATCP] = '\xc8'
 	codeToByte[GMCP] = '\xc9'
+	codeToByte[MSSP] = '\x46'
+
+	codeToByte[CHARSET] = '\x2a'
+	codeToByte[MXP] = '\x5b'
+	codeToByte[EOR] = '\x19'
+	codeToByte[EORC] = '\xef'
 
 	for enum, code := range codeToByte {
 		byteToCode[code] = enum
@@ -228,6 +580,7 @@ const (
 	stateInSB   processorState = iota
 	stateCapSB  processorState = iota
 	stateEscIAC processorState = iota
+	stateInCmd  processorState = iota
 )
 
 // telnetProcessor implements a state machine that reads input one byte at a time
@@ -236,13 +589,18 @@ const (
 // The processor can then be read from with all of the telnet codes removed, leaving
 // the pure user input stream.
 type telnetProcessor struct {
-	state     processorState
-	currentSB TelnetCode
+	state      processorState
+	currentSB  TelnetCode
+	currentCmd TelnetCode
 
-	capturedBytes []byte
 	subdata       map[TelnetCode][]byte
-	cleanData     string
+	subDataBuffer []byte
+	cleanData     ringBuffer
 	listenFunc    func(TelnetCode, []byte)
+	commandFunc   func(TelnetCode, byte)
+
+	// Set when the client uses Latin-1, whose input is converted to UTF-8
+	latin1 bool
 
 	debug bool
 }
@@ -254,48 +612,39 @@ func newTelnetProcessor() telnetProcessor {
 	tp.state = stateBase
 	tp.debug = false
 	tp.currentSB = NUL
+	tp.cleanData = newRingBuffer(readBufferSize)
 
 	return tp
 }
 
+// Read returns the user input that has been processed so far
 func (self *telnetProcessor) Read(p []byte) (int, error) {
-	maxLen := len(p)
-
-	n := 0
-
-	if maxLen >= len(self.cleanData) {
-		n = len(self.cleanData)
-	} else {
-		n = maxLen
-	}
-
-	for i := 0; i < n; i++ {
-		p[i] = self.cleanData[i]
-	}
-
-	self.cleanData = self.cleanData[n:] // TODO: Memory leak?
+	return self.cleanData.Read(p)
+}
 
-	return n, nil
+// Len returns the number of bytes of user input waiting to be read
+func (self *telnetProcessor) Len() int {
+	return self.cleanData.Len()
 }
 
 func (self *telnetProcessor) capture(b byte) {
 	if self.debug {
 		fmt.Println("Captured:", ByteToCodeString(b))
 	}
-
-	self.capturedBytes = append(self.capturedBytes, b)
 }
 
 func (self *telnetProcessor) dontCapture(b byte) {
-	self.cleanData = self.cleanData + string(b)
+	if self.latin1 && b >= utf8.RuneSelf {
+		var encoded [utf8.UTFMax]byte
+		n := utf8.EncodeRune(encoded[:], rune(b))
+		self.cleanData.Write(encoded[:n])
+	} else {
+		self.cleanData.WriteByte(b)
+	}
 }
 
 func (self *telnetProcessor) resetSubDataField(code TelnetCode) {
-	if self.subdata == nil {
-		self.subdata = map[TelnetCode][]byte{}
-	}
-
-	self.subdata[code] = []byte{}
+	self.subDataBuffer = self.subDataBuffer[:0]
 }
 
 func (self *telnetProcessor) captureSubData(code TelnetCode, b byte) {
@@ -303,16 +652,37 @@ func (self *telnetProcessor) captureSubData(code TelnetCode, b byte) {
 		fmt.Println("Captured subdata:", CodeToString(code), b)
 	}
 
-	if self.subdata == nil {
-		self.subdata = map[TelnetCode][]byte{}
-	}
-
-	self.subdata[code] = append(self.subdata[code], b)
+	self.subDataBuffer = append(self.subDataBuffer, b)
 }
 
-func (self *telnetProcessor) addBytes(bytes []byte) {
-	for _, b := range bytes {
-		self.addByte(b)
+func (self *telnetProcessor) addBytes(data []byte) {
+	iac := codeToByte[IAC]
+
+	for len(data) > 0 {
+		// Plain input and subnegotiation data make up nearly everything that's
+		// received, so runs of them are copied in one go rather than going
+		// through the state machine a byte at a time
+		if (self.state == stateBase && !self.latin1) || (self.state == stateCapSB && !self.debug) {
+			end := bytes.IndexByte(data, iac)
+			if end == -1 {
+				end = len(data)
+			}
+
+			if self.state == stateBase {
+				self.cleanData.Write(data[:end])
+			} else {
+				self.subDataBuffer = append(self.subDataBuffer, data[:end]...)
+			}
+
+			data = data[end:]
+
+			if len(data) == 0 {
+				break
+			}
+		}
+
+		self.addByte(data[0])
+		data = data[1:]
 	}
 }
 
@@ -330,7 +700,8 @@ func (self *telnetProcessor) addByte(b byte) {
 
 	case stateInIAC:
 		if code == WILL || code == WONT || code == DO || code == DONT {
-			// Stay in this state
+			self.currentCmd = code
+			self.state = stateInCmd
 		} else if code == SB {
 			self.state = stateInSB
 		} else {
@@ -338,6 +709,14 @@ func (self *telnetProcessor) addByte(b byte) {
 		}
 		self.capture(b)
 
+	case stateInCmd:
+		self.capture(b)
+		self.state = stateBase
+
+		if self.commandFunc != nil {
+			self.commandFunc(self.currentCmd, b)
+		}
+
 	case stateInSB:
 		self.capture(b)
 		self.currentSB = code
@@ -366,6 +745,16 @@ func (self *telnetProcessor) addByte(b byte) {
 }
 
 func (self *telnetProcessor) subDataFinished(code TelnetCode) {
+	if self.subdata == nil {
+		self.subdata = map[TelnetCode][]byte{}
+	}
+
+	// The buffer is reused for the next subnegotiation, whereas this copy is
+	// handed out by Data()
+	data := make([]byte, len(self.subDataBuffer))
+	copy(data, self.subDataBuffer)
+	self.subdata[code] = data
+
 	if self.listenFunc != nil {
 		self.listenFunc(code, self.subdata[code])
 	}
@@ -475,6 +864,16 @@ func CodeToString(code TelnetCode) string {
 		return "ATCP"
 	case GMCP:
 		return "GMCP"
+	case MSSP:
+		return "MSSP"
+	case CHARSET:
+		return "CHARSET"
+	case MXP:
+		return "MXP"
+	case EOR:
+		return "EOR"
+	case EORC:
+		return "EORC"
 	}
 
 	return ""
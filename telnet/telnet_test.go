@@ -2,7 +2,11 @@ package telnet
 
 import (
 	"bufio"
+	"bytes"
+	"compress/zlib"
+	"io"
 	"net"
+	"strings"
 	"testing"
 	"time"
 )
@@ -53,6 +57,18 @@ func (self *fakeConn) SetWriteDeadline(t time.Time) error {
 	return nil
 }
 
+// splitConn keeps what is read from it separate from what is written to it, so
+// that the client and server halves of a conversation can be inspected
+type splitConn struct {
+	fakeConn
+	out []byte
+}
+
+func (self *splitConn) Write(p []byte) (int, error) {
+	self.out = append(self.out, p...)
+	return len(p), nil
+}
+
 func compareData(d1 []byte, d2 []byte) bool {
 	if len(d1) != len(d2) {
 		return false
@@ -183,4 +199,547 @@ func Test_Processor(t *testing.T) {
 	}
 }
 
+func Test_Compression(t *testing.T) {
+	var sc splitConn
+	telnet := NewTelnet(&sc)
+	readBuffer := make([]byte, 1024)
+
+	telnet.WillCompress()
+
+	if compareData(sc.out, BuildCommand(WILL, CMP2)) == false {
+		t.Errorf("WillCompress() sent %v, want %v", sc.out, BuildCommand(WILL, CMP2))
+	}
+
+	sc.out = nil
+	sc.data = BuildCommand(DO, CMP2)
+	telnet.Read(readBuffer)
+
+	if !telnet.Compressed() {
+		t.Errorf("Compression should have started after IAC DO CMP2")
+	}
+
+	testStr := "compressed text"
+	telnet.Write([]byte(testStr))
+
+	start := BuildCommand(SB, CMP2, IAC, SE)
+	if !bytes.HasPrefix(sc.out, start) {
+		t.Fatalf("Compressed stream should start with %v, got %v", start, sc.out)
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(sc.out[len(start):]))
+	if err != nil {
+		t.Fatalf("Failed to open compressed stream: %v", err)
+	}
+
+	result := make([]byte, len(testStr))
+	_, err = io.ReadFull(reader, result)
+
+	if err != nil || string(result) != testStr {
+		t.Errorf("Decompressed '%s' (%v), want '%s'", result, err, testStr)
+	}
+
+	sc.data = BuildCommand(DONT, CMP2)
+	telnet.Read(readBuffer)
+
+	if telnet.Compressed() {
+		t.Errorf("Compression should have stopped after IAC DONT CMP2")
+	}
+}
+
+func Test_GMCP(t *testing.T) {
+	var sc splitConn
+	telnet := NewTelnet(&sc)
+	readBuffer := make([]byte, 1024)
+
+	telnet.SendGMCP("Char.Vitals", map[string]int{"hp": 10})
+
+	if len(sc.out) != 0 {
+		t.Errorf("GMCP data shouldn't be sent before the client enables it: %v", sc.out)
+	}
+
+	sc.data = BuildCommand(DO, GMCP)
+	telnet.Read(readBuffer)
+
+	if compareData(sc.out, BuildCommand(WILL, GMCP)) == false {
+		t.Errorf("Unsolicited IAC DO GMCP should have been agreed to, sent %v", sc.out)
+	}
+
+	sc.out = nil
+	telnet.SendGMCP("Char.Vitals", map[string]int{"hp": 10})
+
+	want := BuildCommand(SB, GMCP)
+	want = append(want, []byte(`Char.Vitals {"hp":10}`)...)
+	want = append(want, BuildCommand(SE)...)
+
+	if compareData(sc.out, want) == false {
+		t.Errorf("SendGMCP() sent %q, want %q", sc.out, want)
+	}
+
+	var hello struct {
+		Client  string
+		Version string
+	}
+
+	module, err := DecodeGMCP([]byte(`Core.Hello { "client": "Mudlet", "version": "4.0" }`), &hello)
+
+	if module != "Core.Hello" || err != nil || hello.Client != "Mudlet" || hello.Version != "4.0" {
+		t.Errorf("DecodeGMCP() == %s, %v, %v", module, hello, err)
+	}
+
+	module, payload := ParseGMCP([]byte("Core.Ping"))
+
+	if module != "Core.Ping" || payload != nil {
+		t.Errorf("ParseGMCP(Core.Ping) == %s, %v", module, payload)
+	}
+}
+
+func Test_Negotiation(t *testing.T) {
+	var sc splitConn
+	telnet := NewTelnet(&sc)
+	readBuffer := make([]byte, 1024)
+
+	receive := func(codes ...TelnetCode) {
+		sc.out = nil
+		sc.data = BuildCommand(codes...)
+		telnet.Read(readBuffer)
+	}
+
+	telnet.DoWindowSize()
+
+	if compareData(sc.out, BuildCommand(DO, WS)) == false {
+		t.Errorf("DoWindowSize() sent %v, want %v", sc.out, BuildCommand(DO, WS))
+	}
+
+	if telnet.Enabled(WS) {
+		t.Errorf("WS shouldn't be enabled until the client agrees to it")
+	}
+
+	// An acknowledgement must not be answered, otherwise both ends loop
+	receive(WILL, WS)
+
+	if !telnet.Enabled(WS) || !telnet.RemoteEnabled(WS) || telnet.LocalEnabled(WS) {
+		t.Errorf("WS should be enabled on the client's side only")
+	}
+
+	if len(sc.out) != 0 {
+		t.Errorf("IAC WILL WS shouldn't have been answered, sent %v", sc.out)
+	}
+
+	receive(WILL, WS)
+
+	if len(sc.out) != 0 {
+		t.Errorf("Repeated IAC WILL WS shouldn't have been answered, sent %v", sc.out)
+	}
+
+	receive(WONT, WS)
+
+	if telnet.Enabled(WS) {
+		t.Errorf("WS should have been disabled")
+	}
+
+	if compareData(sc.out, BuildCommand(DONT, WS)) == false {
+		t.Errorf("IAC WONT WS should have been acknowledged, sent %v", sc.out)
+	}
+
+	// Options that we don't support get refused, even if we don't know them
+	sc.out = nil
+	sc.data = []byte{codeToByte[IAC], codeToByte[WILL], '\x99'}
+	telnet.Read(readBuffer)
+
+	want := []byte{codeToByte[IAC], codeToByte[DONT], '\x99'}
+	if compareData(sc.out, want) == false {
+		t.Errorf("Unknown option should have been refused, sent %v, want %v", sc.out, want)
+	}
+
+	receive(DO, ECHO)
+
+	if telnet.Enabled(ECHO) || compareData(sc.out, BuildCommand(WONT, ECHO)) == false {
+		t.Errorf("Unsolicited IAC DO ECHO should have been refused, sent %v", sc.out)
+	}
+
+	// Changing our mind while a request is in flight queues the change
+	sc.out = nil
+	telnet.WillEcho()
+	telnet.WontEcho()
+
+	if compareData(sc.out, BuildCommand(WILL, ECHO)) == false {
+		t.Errorf("WillEcho()/WontEcho() sent %v, want %v", sc.out, BuildCommand(WILL, ECHO))
+	}
+
+	receive(DO, ECHO)
+
+	if telnet.Enabled(ECHO) || compareData(sc.out, BuildCommand(WONT, ECHO)) == false {
+		t.Errorf("Queued WONT ECHO should have been sent, sent %v", sc.out)
+	}
+
+	receive(DONT, ECHO)
+
+	if telnet.Enabled(ECHO) || len(sc.out) != 0 {
+		t.Errorf("IAC DONT ECHO shouldn't have been answered, sent %v", sc.out)
+	}
+}
+
+func Test_TerminalTypeRequest(t *testing.T) {
+	var sc splitConn
+	telnet := NewTelnet(&sc)
+	readBuffer := make([]byte, 1024)
+
+	telnet.DoTerminalType()
+	sc.out = nil
+
+	sc.data = BuildCommand(WILL, TT)
+	telnet.Read(readBuffer)
+
+	want := BuildCommand(SB, TT, 1, IAC, SE)
+	if compareData(sc.out, want) == false {
+		t.Errorf("Terminal type should be requested once the client agrees, sent %v, want %v", sc.out, want)
+	}
+}
+
+func Test_MSSP(t *testing.T) {
+	var sc splitConn
+	telnet := NewTelnet(&sc)
+	readBuffer := make([]byte, 1024)
+
+	telnet.WillMSSP(func() map[string]string {
+		return map[string]string{"PLAYERS": "3", "NAME": "kmud"}
+	})
+
+	if compareData(sc.out, BuildCommand(WILL, MSSP)) == false {
+		t.Errorf("WillMSSP() sent %v, want %v", sc.out, BuildCommand(WILL, MSSP))
+	}
+
+	sc.out = nil
+	sc.data = BuildCommand(DO, MSSP)
+	telnet.Read(readBuffer)
+
+	want := BuildCommand(SB, MSSP)
+	want = append(want, []byte("\x01NAME\x02kmud\x01PLAYERS\x023")...)
+	want = append(want, BuildCommand(SE)...)
+
+	if compareData(sc.out, want) == false {
+		t.Errorf("MSSP status sent %q, want %q", sc.out, want)
+	}
+}
+
+func Test_TerminalTypeCycling(t *testing.T) {
+	var sc splitConn
+	telnet := NewTelnet(&sc)
+	readBuffer := make([]byte, 1024)
+
+	send := BuildCommand(SB, TT, 1, IAC, SE)
+
+	reported := false
+	telnet.Listen(func(code TelnetCode, data []byte) {
+		if code == TT {
+			reported = true
+		}
+	})
+
+	answer := func(tt string) {
+		sc.out = nil
+		sc.data = BuildCommand(SB, TT, NUL)
+		sc.data = append(sc.data, []byte(tt)...)
+		sc.data = append(sc.data, BuildCommand(SE)...)
+		telnet.Read(readBuffer)
+	}
+
+	answer("MUDLET")
+	answer("XTERM-256COLOR")
+
+	if compareData(sc.out, send) == false || reported {
+		t.Errorf("Should keep asking for terminal types, sent %v", sc.out)
+	}
+
+	answer("MTTS 137")
+	answer("MTTS 137")
+
+	if len(sc.out) != 0 || !reported {
+		t.Errorf("Should stop asking once the client repeats itself, sent %v", sc.out)
+	}
+
+	types := telnet.TerminalTypes()
+	if len(types) != 3 || types[0] != "MUDLET" || types[2] != "MTTS 137" {
+		t.Errorf("TerminalTypes() == %v", types)
+	}
+
+	mtts := DetectMTTS(types)
+	if mtts != MttsANSI|Mtts256Colors|MttsProxy || !mtts.Has(MttsANSI|MttsProxy) || mtts.Has(MttsUTF8) {
+		t.Errorf("DetectMTTS(%v) == %v", types, mtts)
+	}
+
+	var tests = []struct {
+		types []string
+		want  MTTS
+	}{
+		{[]string{"xterm"}, MttsANSI},
+		{[]string{"XTERM-256COLOR"}, MttsANSI | Mtts256Colors},
+		{[]string{"VT100"}, MttsVT100},
+		{[]string{"dumb"}, 0},
+		{[]string{}, 0},
+	}
+
+	for _, test := range tests {
+		if got := DetectMTTS(test.types); got != test.want {
+			t.Errorf("DetectMTTS(%v) == %v, want %v", test.types, got, test.want)
+		}
+	}
+}
+
+func Test_Charset(t *testing.T) {
+	var sc splitConn
+	telnet := NewTelnet(&sc)
+	readBuffer := make([]byte, 1024)
+
+	subnegotiation := func(data string) []byte {
+		command := BuildCommand(SB, CHARSET)
+		command = append(command, []byte(data)...)
+		return append(command, BuildCommand(SE)...)
+	}
+
+	telnet.WillCharset()
+	sc.out = nil
+
+	sc.data = BuildCommand(DO, CHARSET)
+	telnet.Read(readBuffer)
+
+	want := subnegotiation("\x01;UTF-8;ISO-8859-1")
+	if compareData(sc.out, want) == false {
+		t.Errorf("Charset request sent %q, want %q", sc.out, want)
+	}
+
+	sc.data = subnegotiation("\x02iso-8859-1")
+	telnet.Read(readBuffer)
+
+	if telnet.Charset() != CharsetLatin1 {
+		t.Errorf("Charset() == %q, want %q", telnet.Charset(), CharsetLatin1)
+	}
+
+	sc.out = nil
+	telnet.Write([]byte("caf\u00e9 \u00ff \u4e16"))
+
+	if string(sc.out) != "caf\xe9 \xff\xff ?" {
+		t.Errorf("Latin-1 output == %q", sc.out)
+	}
+
+	sc.data = []byte("caf\xe9")
+	n, _ := telnet.Read(readBuffer)
+
+	if string(readBuffer[:n]) != "caf\u00e9" {
+		t.Errorf("Latin-1 input == %q, want %q", readBuffer[:n], "caf\u00e9")
+	}
+
+	sc.out = nil
+	sc.data = subnegotiation("\x01 KOI8-R utf8")
+	telnet.Read(readBuffer)
+
+	want = subnegotiation("\x02utf8")
+	if compareData(sc.out, want) == false || telnet.Charset() != CharsetUTF8 {
+		t.Errorf("Charset reply sent %q, want %q", sc.out, want)
+	}
+
+	sc.data = []byte("caf\u00e9")
+	n, _ = telnet.Read(readBuffer)
+
+	if string(readBuffer[:n]) != "caf\u00e9" {
+		t.Errorf("UTF-8 input == %q, want %q", readBuffer[:n], "caf\u00e9")
+	}
+}
+
+func Test_MarkPrompt(t *testing.T) {
+	var sc splitConn
+	telnet := NewTelnet(&sc)
+	readBuffer := make([]byte, 1024)
+
+	telnet.MarkPrompt()
+
+	if compareData(sc.out, BuildCommand(GA)) == false {
+		t.Errorf("Prompt marked with %v, want IAC GA", sc.out)
+	}
+
+	telnet.WillEOR()
+	sc.data = BuildCommand(DO, EOR)
+	telnet.Read(readBuffer)
+	sc.out = nil
+
+	telnet.MarkPrompt()
+
+	if compareData(sc.out, BuildCommand(EORC)) == false {
+		t.Errorf("Prompt marked with %v, want IAC EOR", sc.out)
+	}
+
+	sc.data = BuildCommand(DONT, EOR)
+	telnet.Read(readBuffer)
+	sc.data = BuildCommand(DO, SGA)
+	telnet.Read(readBuffer)
+	sc.out = nil
+
+	telnet.MarkPrompt()
+
+	if len(sc.out) != 0 {
+		t.Errorf("Prompt marked with %v while go ahead is suppressed", sc.out)
+	}
+}
+
+func Test_Ping(t *testing.T) {
+	var sc splitConn
+	telnet := NewTelnet(&sc)
+
+	if err := telnet.Ping(); err != nil {
+		t.Errorf("Ping() failed: %v", err)
+	}
+
+	if compareData(sc.out, BuildCommand(NOP)) == false {
+		t.Errorf("Ping() sent %v, want IAC NOP", sc.out)
+	}
+}
+
+func Test_CharMode(t *testing.T) {
+	var sc splitConn
+	telnet := NewTelnet(&sc)
+	readBuffer := make([]byte, 1024)
+
+	telnet.SetCharMode(true)
+
+	want := append(BuildCommand(WILL, SGA), BuildCommand(WILL, ECHO)...)
+	if compareData(sc.out, want) == false {
+		t.Errorf("SetCharMode(true) sent %v, want %v", sc.out, want)
+	}
+
+	sc.data = append(BuildCommand(DO, SGA), BuildCommand(DO, ECHO)...)
+	telnet.Read(readBuffer)
+
+	if !telnet.CharMode() {
+		t.Errorf("CharMode() == false after the client agreed to it")
+	}
+
+	sc.out = nil
+	telnet.SetCharMode(false)
+
+	want = append(BuildCommand(WONT, ECHO), BuildCommand(WONT, SGA)...)
+	if compareData(sc.out, want) == false || telnet.CharMode() {
+		t.Errorf("SetCharMode(false) sent %v, want %v", sc.out, want)
+	}
+}
+
+func Test_MXP(t *testing.T) {
+	var sc splitConn
+	telnet := NewTelnet(&sc)
+	readBuffer := make([]byte, 1024)
+
+	telnet.WillMXP()
+	sc.out = nil
+
+	sc.data = BuildCommand(DO, MXP)
+	telnet.Read(readBuffer)
+
+	want := BuildCommand(SB, MXP, IAC, SE)
+	if compareData(sc.out, want) == false || !telnet.MXPEnabled() {
+		t.Errorf("MXP start sent %v, want %v", sc.out, want)
+	}
+}
+
+// eofConn returns all of its data along with io.EOF from a single Read
+type eofConn struct {
+	fakeConn
+}
+
+func (self *eofConn) Read(p []byte) (int, error) {
+	n, _ := self.fakeConn.Read(p)
+	return n, io.EOF
+}
+
+func Test_ReadEOF(t *testing.T) {
+	var ec eofConn
+	telnet := NewTelnet(&ec)
+
+	ec.data = append([]byte("last words"), BuildCommand(WILL, ECHO)...)
+	ec.data = append(ec.data, []byte(" and more")...)
+
+	readBuffer := make([]byte, 4)
+	var result []byte
+
+	for {
+		n, err := telnet.Read(readBuffer)
+		result = append(result, readBuffer[:n]...)
+
+		if err == io.EOF {
+			break
+		} else if err != nil || n == 0 {
+			t.Fatalf("Read() == %v, %v before EOF", n, err)
+		}
+	}
+
+	if string(result) != "last words and more" {
+		t.Errorf("Read %q before EOF, want %q", result, "last words and more")
+	}
+
+	if n, err := telnet.Read(readBuffer); n != 0 || err != io.EOF {
+		t.Errorf("Read() after EOF == %v, %v, want 0, EOF", n, err)
+	}
+}
+
+// benchConn serves its input once per benchmark iteration, followed by io.EOF
+type benchConn struct {
+	fakeConn
+}
+
+func (self *benchConn) Read(p []byte) (int, error) {
+	if len(self.data) == 0 {
+		return 0, io.EOF
+	}
+
+	return self.fakeConn.Read(p)
+}
+
+func (self *benchConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func benchmarkRead(b *testing.B, input []byte) {
+	var conn benchConn
+	telnet := NewTelnet(&conn)
+	readBuffer := make([]byte, 1024)
+
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		conn.data = input
+
+		for {
+			if _, err := telnet.Read(readBuffer); err == io.EOF {
+				break
+			}
+		}
+	}
+}
+
+// Someone pasting a large block of text
+func Benchmark_ReadPaste(b *testing.B) {
+	line := "The quick brown fox jumps over the lazy dog, again and again and again.\r\n"
+	input := []byte(strings.Repeat(line, 1000))
+	benchmarkRead(b, input)
+}
+
+// A bot firing off short commands, mixed in with the telnet traffic a MUD
+// client generates
+func Benchmark_ReadBot(b *testing.B) {
+	var input []byte
+
+	for i := 0; i < 1000; i++ {
+		input = append(input, []byte("kill rat\r\nn\r\nget all\r\n")...)
+		input = append(input, BuildCommand(NOP)...)
+		input = append(input, BuildCommand(SB, GMCP)...)
+		input = append(input, []byte("Core.Ping")...)
+		input = append(input, BuildCommand(SE)...)
+		input = append(input, BuildCommand(SB, WS)...)
+		input = append(input, 0, 80, 0, 24)
+		input = append(input, BuildCommand(SE)...)
+	}
+
+	benchmarkRead(b, input)
+}
+
 // vim: nocindent
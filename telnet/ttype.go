@@ -0,0 +1,120 @@
+package telnet
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Terminal type cycling (RFC 1091, http://tools.ietf.org/html/rfc1091) and the
+// Mud Terminal Type Standard (http://tintin.sourceforge.net/mtts/).
+//
+// Every IAC SB TTYPE SEND makes the client answer with its next terminal type.
+// MTTS clients answer with their client name first, then their terminal type,
+// then "MTTS <bitfield>", and keep repeating the last answer after that.
+// Non-MTTS clients either repeat a single answer or cycle back to the first.
+
+// MTTS is the bitfield of capabilities reported by MTTS compliant clients
+type MTTS int
+
+const (
+	MttsANSI            MTTS = 1
+	MttsVT100           MTTS = 2
+	MttsUTF8            MTTS = 4
+	Mtts256Colors       MTTS = 8
+	MttsMouseTracking   MTTS = 16
+	MttsOSCColorPalette MTTS = 32
+	MttsScreenReader    MTTS = 64
+	MttsProxy           MTTS = 128
+	MttsTrueColor       MTTS = 256
+	MttsMNES            MTTS = 512
+	MttsMSLP            MTTS = 1024
+	MttsSSL             MTTS = 2048
+)
+
+// Upper bound on the number of terminal types requested from a single client,
+// in case it never repeats itself
+const maxTerminalTypes = 8
+
+// Has returns true if all of the given flags are set
+func (self MTTS) Has(flags MTTS) bool {
+	return self&flags == flags
+}
+
+// receiveTerminalType records one answer to TTYPE SEND (the subnegotiation
+// data, including the leading IS byte). It returns true once the client has
+// run out of new answers, otherwise the next answer is requested.
+func (t *Telnet) receiveTerminalType(data []byte) bool {
+	if len(data) > 0 && data[0] == 0 { // 0 = IS
+		data = data[1:]
+	}
+
+	tt := string(data)
+
+	t.ttMutex.Lock()
+	done := false
+
+	count := len(t.terminalTypes)
+	if count > 0 && (tt == t.terminalTypes[count-1] || tt == t.terminalTypes[0]) {
+		done = true
+	} else {
+		t.terminalTypes = append(t.terminalTypes, tt)
+		done = len(t.terminalTypes) >= maxTerminalTypes
+	}
+	t.ttMutex.Unlock()
+
+	if !done {
+		t.SendCommand(SB, TT, 1, IAC, SE) // 1 = SEND
+	}
+
+	return done
+}
+
+// TerminalTypes returns every distinct terminal type the client has reported,
+// in the order it reported them
+func (t *Telnet) TerminalTypes() []string {
+	t.ttMutex.Lock()
+	defer t.ttMutex.Unlock()
+
+	types := make([]string, len(t.terminalTypes))
+	copy(types, t.terminalTypes)
+	return types
+}
+
+// DetectMTTS determines the capabilities of a client from the terminal types
+// it reported. The MTTS bitfield is used when present, otherwise the terminal
+// names are matched against well known values.
+func DetectMTTS(types []string) MTTS {
+	var mtts MTTS
+
+	for _, tt := range types {
+		fields := strings.Fields(strings.ToUpper(tt))
+
+		if len(fields) == 2 && fields[0] == "MTTS" {
+			value, err := strconv.Atoi(fields[1])
+			if err == nil {
+				return MTTS(value)
+			}
+		}
+	}
+
+	for _, tt := range types {
+		tt = strings.ToUpper(tt)
+
+		if strings.HasSuffix(tt, "-256COLOR") {
+			mtts |= MttsANSI | Mtts256Colors
+		} else if strings.HasSuffix(tt, "-TRUECOLOR") {
+			mtts |= MttsANSI | Mtts256Colors | MttsTrueColor
+		}
+
+		switch strings.SplitN(tt, "-", 2)[0] {
+		case "ANSI", "XTERM", "LINUX", "SCREEN", "RXVT", "PUTTY":
+			mtts |= MttsANSI
+		case "VT100", "VT102", "VT220":
+			mtts |= MttsVT100
+		}
+	}
+
+	return mtts
+}
+
+// vim: nocindent
@@ -156,4 +156,9 @@ func processColors(text string, cm ColorMode) string {
 	return after
 }
 
+// StripColors removes all MUD color codes from the given text
+func StripColors(text string) string {
+	return processColors(text, ColorModeNone)
+}
+
 // vim: nocindent
@@ -0,0 +1,376 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Completer returns every word that could be typed at the end of the given
+// line. The LineEditor picks out the ones that match what has been typed so far.
+type Completer func(line string) []string
+
+// Maximum number of lines remembered by a LineEditor
+const maxHistory = 100
+
+// Control characters handled by the LineEditor
+const (
+	keyBackspace = '\x08'
+	keyTab       = '\t'
+	keyLF        = '\n'
+	keyCR        = '\r'
+	keyKillLine  = '\x15' // Ctrl-U
+	keyEscape    = '\x1b'
+	keyDelete    = '\x7f'
+)
+
+// LineEditor reads input one character at a time, for clients that have been
+// put into character mode, echoing it back and handling basic line editing:
+// backspace, left/right arrows, up/down arrows for history, Ctrl-U to erase
+// the line and tab to complete the word under the cursor
+type LineEditor struct {
+	Completer Completer
+
+	echo func() bool
+
+	history      []string
+	historyIndex int
+	draft        []rune
+
+	line   []rune
+	cursor int
+
+	// Set after a line ended with CR, so that the LF or NUL that follows it
+	// isn't taken to be the end of another line
+	skipLF bool
+}
+
+// NewLineEditor creates a LineEditor. echo reports whether the client has
+// left echoing up to us, when it hasn't nothing is echoed and the editor
+// simply collects lines.
+func NewLineEditor(echo func() bool) *LineEditor {
+	var editor LineEditor
+	editor.echo = echo
+	return &editor
+}
+
+// lineEditing is implemented by connections that have been put into
+// character mode
+type lineEditing interface {
+	LineEditor() *LineEditor
+}
+
+func lineEditorFor(conn io.ReadWriter) *LineEditor {
+	if editing, ok := conn.(lineEditing); ok {
+		return editing.LineEditor()
+	}
+
+	return nil
+}
+
+// History returns the lines that have been entered, oldest first
+func (self *LineEditor) History() []string {
+	history := make([]string, len(self.history))
+	copy(history, self.history)
+	return history
+}
+
+// ReadLine reads a line of input from the connection. The prompt should
+// already have been written, it's used to redraw the line when needed.
+func (self *LineEditor) ReadLine(conn io.ReadWriter, prompt string, cm ColorMode) (string, error) {
+	self.line = self.line[:0]
+	self.cursor = 0
+	self.draft = nil
+	self.historyIndex = len(self.history)
+
+	var char [utf8.UTFMax]byte
+	charLen := 0
+
+	escape := 0 // 0: not in a sequence, 1: after ESC, 2: after ESC [ or ESC O
+
+	buf := make([]byte, 1)
+
+	for {
+		n, err := conn.Read(buf)
+
+		if err != nil {
+			return "", err
+		} else if n == 0 {
+			continue
+		}
+
+		b := buf[0]
+
+		if self.skipLF {
+			self.skipLF = false
+
+			if b == keyLF || b == 0 {
+				continue
+			}
+		}
+
+		if escape == 1 {
+			if b == '[' || b == 'O' {
+				escape = 2
+			} else {
+				escape = 0
+			}
+			continue
+		} else if escape == 2 {
+			// Parameters (e.g. the 3 in ESC [ 3 ~) are skipped until the final byte
+			if b >= 0x40 && b <= 0x7e {
+				escape = 0
+				self.arrow(conn, b, prompt, cm)
+			}
+			continue
+		}
+
+		if charLen > 0 || b >= utf8.RuneSelf {
+			char[charLen] = b
+			charLen++
+
+			if !utf8.FullRune(char[:charLen]) && charLen < utf8.UTFMax {
+				continue
+			}
+
+			r, _ := utf8.DecodeRune(char[:charLen])
+			charLen = 0
+
+			if r != utf8.RuneError {
+				self.insert(conn, r, prompt, cm)
+			}
+			continue
+		}
+
+		switch b {
+		case keyCR, keyLF:
+			self.skipLF = b == keyCR
+			return self.finish(conn), nil
+		case keyBackspace, keyDelete:
+			self.backspace(conn, prompt, cm)
+		case keyKillLine:
+			self.line = self.line[:0]
+			self.cursor = 0
+			self.redraw(conn, prompt, cm)
+		case keyTab:
+			self.complete(conn, prompt, cm)
+		case keyEscape:
+			escape = 1
+		default:
+			if b >= ' ' {
+				self.insert(conn, rune(b), prompt, cm)
+			}
+		}
+	}
+}
+
+func (self *LineEditor) echoing() bool {
+	return self.echo != nil && self.echo()
+}
+
+func (self *LineEditor) write(conn io.Writer, text string) {
+	if !self.echoing() {
+		return
+	}
+
+	// Input echoed to an MXP client mustn't be mistaken for markup
+	if mxpEnabled(conn) {
+		text = mxpEscaper.Replace(text)
+	}
+
+	io.WriteString(conn, text)
+}
+
+// redraw rewrites the whole line, leaving the cursor where it belongs
+func (self *LineEditor) redraw(conn io.Writer, prompt string, cm ColorMode) {
+	if !self.echoing() {
+		return
+	}
+
+	Write(conn, "\r"+prompt, cm)
+	self.write(conn, string(self.line)+"\x1b[K")
+	self.moveLeft(conn, self.widthAfterCursor())
+}
+
+func (self *LineEditor) widthAfterCursor() int {
+	width := 0
+	for _, r := range self.line[self.cursor:] {
+		width += RuneWidth(r)
+	}
+	return width
+}
+
+func (self *LineEditor) moveLeft(conn io.Writer, columns int) {
+	if columns > 0 {
+		self.write(conn, fmt.Sprintf("\x1b[%dD", columns))
+	}
+}
+
+func (self *LineEditor) moveRight(conn io.Writer, columns int) {
+	if columns > 0 {
+		self.write(conn, fmt.Sprintf("\x1b[%dC", columns))
+	}
+}
+
+func (self *LineEditor) insert(conn io.Writer, r rune, prompt string, cm ColorMode) {
+	self.line = append(self.line, 0)
+	copy(self.line[self.cursor+1:], self.line[self.cursor:])
+	self.line[self.cursor] = r
+	self.cursor++
+
+	// Typing at the end of the line is the usual case, and only needs the new
+	// character to be echoed
+	if self.cursor == len(self.line) {
+		self.write(conn, string(r))
+	} else {
+		self.redraw(conn, prompt, cm)
+	}
+}
+
+func (self *LineEditor) backspace(conn io.Writer, prompt string, cm ColorMode) {
+	if self.cursor == 0 {
+		return
+	}
+
+	r := self.line[self.cursor-1]
+	self.line = append(self.line[:self.cursor-1], self.line[self.cursor:]...)
+	self.cursor--
+
+	if self.cursor == len(self.line) {
+		width := RuneWidth(r)
+		self.write(conn, strings.Repeat("\b", width)+strings.Repeat(" ", width)+strings.Repeat("\b", width))
+	} else {
+		self.redraw(conn, prompt, cm)
+	}
+}
+
+func (self *LineEditor) arrow(conn io.Writer, key byte, prompt string, cm ColorMode) {
+	switch key {
+	case 'A': // Up
+		if self.historyIndex == 0 {
+			return
+		}
+
+		if self.historyIndex == len(self.history) {
+			self.draft = append([]rune(nil), self.line...)
+		}
+
+		self.historyIndex--
+		self.setLine([]rune(self.history[self.historyIndex]))
+		self.redraw(conn, prompt, cm)
+
+	case 'B': // Down
+		if self.historyIndex >= len(self.history) {
+			return
+		}
+
+		self.historyIndex++
+
+		if self.historyIndex == len(self.history) {
+			self.setLine(self.draft)
+		} else {
+			self.setLine([]rune(self.history[self.historyIndex]))
+		}
+		self.redraw(conn, prompt, cm)
+
+	case 'C': // Right
+		if self.cursor < len(self.line) {
+			self.moveRight(conn, RuneWidth(self.line[self.cursor]))
+			self.cursor++
+		}
+
+	case 'D': // Left
+		if self.cursor > 0 {
+			self.cursor--
+			self.moveLeft(conn, RuneWidth(self.line[self.cursor]))
+		}
+	}
+}
+
+func (self *LineEditor) setLine(line []rune) {
+	self.line = append(self.line[:0], line...)
+	self.cursor = len(self.line)
+}
+
+// complete finishes the word before the cursor. A single match is filled in
+// completely, several matches are filled in as far as they agree and listed if
+// that doesn't get any further.
+func (self *LineEditor) complete(conn io.Writer, prompt string, cm ColorMode) {
+	if self.Completer == nil {
+		return
+	}
+
+	start := self.cursor
+	for start > 0 && self.line[start-1] != ' ' {
+		start--
+	}
+
+	word := string(self.line[start:self.cursor])
+
+	var matches []string
+	for _, candidate := range self.Completer(string(self.line[:start])) {
+		if strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(word)) {
+			matches = append(matches, candidate)
+		}
+	}
+
+	if len(matches) == 0 {
+		self.write(conn, "\a")
+		return
+	}
+
+	completion := matches[0] + " "
+
+	if len(matches) > 1 {
+		completion = commonPrefix(matches)
+
+		if utf8.RuneCountInString(completion) <= utf8.RuneCountInString(word) {
+			self.write(conn, "\r\n"+strings.Join(matches, "  ")+"\r\n")
+			self.redraw(conn, prompt, cm)
+			return
+		}
+	}
+
+	rest := append([]rune(completion), self.line[self.cursor:]...)
+	self.line = append(self.line[:start], rest...)
+	self.cursor = start + utf8.RuneCountInString(completion)
+	self.redraw(conn, prompt, cm)
+}
+
+// commonPrefix returns the longest case-insensitive prefix shared by all of
+// the given strings, in the case of the first one
+func commonPrefix(words []string) string {
+	prefix := []rune(words[0])
+
+	for _, word := range words[1:] {
+		runes := []rune(word)
+
+		i := 0
+		for i < len(prefix) && i < len(runes) && strings.EqualFold(string(prefix[i]), string(runes[i])) {
+			i++
+		}
+
+		prefix = prefix[:i]
+	}
+
+	return string(prefix)
+}
+
+func (self *LineEditor) finish(conn io.Writer) string {
+	self.write(conn, "\r\n")
+
+	line := string(self.line)
+
+	if strings.TrimSpace(line) != "" && (len(self.history) == 0 || self.history[len(self.history)-1] != line) {
+		self.history = append(self.history, line)
+
+		if len(self.history) > maxHistory {
+			self.history = self.history[len(self.history)-maxHistory:]
+		}
+	}
+
+	return line
+}
+
+// vim: nocindent
@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+type editorConn struct {
+	input  string
+	output string
+}
+
+func (self *editorConn) Read(p []byte) (int, error) {
+	if len(self.input) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, self.input)
+	self.input = self.input[n:]
+	return n, nil
+}
+
+func (self *editorConn) Write(p []byte) (int, error) {
+	self.output += string(p)
+	return len(p), nil
+}
+
+func echoOn() bool {
+	return true
+}
+
+func Test_LineEditor(t *testing.T) {
+	var tests = []struct {
+		input  string
+		output string
+	}{
+		{"look\r\n", "look"},
+		{"look\r\x00", "look"},
+		{"look\n", "look"},
+		{"lpp\x7f\x7fook\r", "look"},
+		{"lpp\b\book\r", "look"},
+		{"say hi\x15look\r", "look"},
+		{"lk\x1b[Do\r", "lok"},
+		{"lk\x1b[D\x1b[D\x1b[C\x1b[Co\r", "lko"},
+		{"ab\x1b[3~c\r", "abc"},
+		{"café\r", "café"},
+		{"\x01\x02look\r", "look"},
+	}
+
+	for _, test := range tests {
+		conn := &editorConn{input: test.input}
+		editor := NewLineEditor(echoOn)
+
+		line, err := editor.ReadLine(conn, "> ", ColorModeNone)
+
+		if line != test.output || err != nil {
+			t.Errorf("ReadLine(%q) == %q, %v, want %q", test.input, line, err, test.output)
+		}
+	}
+}
+
+func Test_LineEditorEcho(t *testing.T) {
+	conn := &editorConn{input: "lok\x7fok\r"}
+	editor := NewLineEditor(echoOn)
+	editor.ReadLine(conn, "> ", ColorModeNone)
+
+	if conn.output != "lok\b \bok\r\n" {
+		t.Errorf("Echoed %q, want %q", conn.output, "lok\b \bok\r\n")
+	}
+
+	conn = &editorConn{input: "look\r"}
+	editor = NewLineEditor(func() bool { return false })
+	editor.ReadLine(conn, "> ", ColorModeNone)
+
+	if conn.output != "" {
+		t.Errorf("Echoed %q with echo off, want nothing", conn.output)
+	}
+}
+
+func Test_LineEditorHistory(t *testing.T) {
+	conn := &editorConn{input: "north\r\nsouth\r\n\x1b[A\x1b[A\r\nwe\x1b[A\x1b[B\x1b[Bst\r\n"}
+	editor := NewLineEditor(echoOn)
+
+	want := []string{"north", "south", "north", "west"}
+
+	for _, w := range want {
+		line, _ := editor.ReadLine(conn, "> ", ColorModeNone)
+
+		if line != w {
+			t.Errorf("ReadLine() == %q, want %q", line, w)
+		}
+	}
+
+	history := editor.History()
+	wantHistory := []string{"north", "south", "north", "west"}
+
+	if strings.Join(history, ",") != strings.Join(wantHistory, ",") {
+		t.Errorf("History() == %v, want %v", history, wantHistory)
+	}
+}
+
+func Test_LineEditorCompletion(t *testing.T) {
+	completer := func(line string) []string {
+		if line == "" {
+			return []string{"look", "location", "say", "/tell"}
+		}
+		return []string{"Bob", "Bobby", "Alice"}
+	}
+
+	var tests = []struct {
+		input  string
+		output string
+	}{
+		{"sa\t\r", "say "},
+		{"lo\t\r", "lo"},
+		{"loo\t\r", "look "},
+		{"/t\t\r", "/tell "},
+		{"say a\t\r", "say Alice "},
+		{"say b\t\r", "say Bob"},
+		{"z\t\r", "z"},
+		{"sa\x1b[D\x1b[D\t\r", "sa"},
+	}
+
+	for _, test := range tests {
+		conn := &editorConn{input: test.input}
+		editor := NewLineEditor(echoOn)
+		editor.Completer = completer
+
+		line, _ := editor.ReadLine(conn, "> ", ColorModeNone)
+
+		if line != test.output {
+			t.Errorf("ReadLine(%q) == %q, want %q", test.input, line, test.output)
+		}
+	}
+
+	// Listing the possible matches when they can't be narrowed down
+	conn := &editorConn{input: "lo\t\r"}
+	editor := NewLineEditor(echoOn)
+	editor.Completer = completer
+	editor.ReadLine(conn, "> ", ColorModeNone)
+
+	if !strings.Contains(conn.output, "\r\nlook  location\r\n") {
+		t.Errorf("Completion output %q doesn't list the matches", conn.output)
+	}
+}
+
+// vim: nocindent
@@ -91,12 +91,31 @@ func (self *Menu) Print(conn io.Writer, cm ColorMode) {
 	title := Colorize(ColorBlue, self.title)
 	WriteLine(conn, fmt.Sprintf("%s %s %s", border, title, border), cm)
 
+	// Keys that can't be highlighted within their text are printed in front
+	// of it, right aligned so that the text lines up in a column
+	keyIndex := func(action action) int {
+		// Lowercasing can change the length of some UTF-8 text, in which case
+		// the index can't be used to slice the original
+		if len(strings.ToLower(action.text)) != len(action.text) {
+			return -1
+		}
+		return strings.Index(strings.ToLower(action.text), action.key)
+	}
+
+	keyWidth := 0
 	for _, action := range self.actions {
-		index := strings.Index(strings.ToLower(action.text), action.key)
+		if keyIndex(action) == -1 && DisplayWidth(action.key) > keyWidth {
+			keyWidth = DisplayWidth(action.key)
+		}
+	}
 
+	for _, action := range self.actions {
+		index := keyIndex(action)
+		padding := ""
 		actionText := ""
 
 		if index == -1 {
+			padding = strings.Repeat(" ", keyWidth-DisplayWidth(action.key))
 			actionText = fmt.Sprintf("%s[%s%s%s]%s%s",
 				ColorDarkBlue,
 				ColorBlue,
@@ -116,7 +135,7 @@ func (self *Menu) Print(conn io.Writer, cm ColorMode) {
 				action.text[index+keyLength:])
 		}
 
-		WriteLine(conn, fmt.Sprintf("  %s", actionText), cm)
+		WriteLine(conn, fmt.Sprintf("  %s%s", padding, Link(action.key, actionText)), cm)
 	}
 }
 
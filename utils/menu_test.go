@@ -56,3 +56,18 @@ func Test_Print(t *testing.T) {
 	testutils.Assert(strings.Contains(writer.Wrote, "[1]Action2"), t, "Didn't have Action2")
 	testutils.Assert(strings.Contains(writer.Wrote, "A[c]tion3"), t, "Didn't have Action3")
 }
+
+func Test_PrintAlignsKeys(t *testing.T) {
+	menu := NewMenu("align test")
+
+	for i := 1; i <= 10; i++ {
+		menu.AddActionData(i, "Entry", "")
+	}
+
+	writer := &testutils.TestWriter{}
+
+	menu.Print(writer, ColorModeNone)
+
+	testutils.Assert(strings.Contains(writer.Wrote, "   [1]Entry"), t, "Single digit key wasn't padded")
+	testutils.Assert(strings.Contains(writer.Wrote, "  [10]Entry"), t, "Double digit key was padded")
+}
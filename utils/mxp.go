@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"strings"
+)
+
+// MUD eXtension Protocol markup: http://www.zuggsoft.com/zmud/mxp.htm
+//
+// Links are embedded in text the same way colors are, and are turned into MXP
+// <send> tags when the text is written to a client that supports MXP. Everyone
+// else just gets the text of the link.
+
+const (
+	linkStart     = "\x02"
+	linkSeparator = "\x1f"
+	linkEnd       = "\x03"
+)
+
+// MXP escape sequence that puts the next tag into secure mode, which <send>
+// tags require
+const mxpTempSecure = "\x1b[4z"
+
+var mxpEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;")
+
+var linkEscaper = strings.NewReplacer(linkStart, "", linkSeparator, "", linkEnd, "")
+
+// mxpChecker is implemented by connections that are able to tell whether the
+// client has agreed to MXP
+type mxpChecker interface {
+	MXPEnabled() bool
+}
+
+func mxpEnabled(conn interface{}) bool {
+	if checker, ok := conn.(mxpChecker); ok {
+		return checker.MXPEnabled()
+	}
+
+	return false
+}
+
+// Link marks the given text as a link that sends the given command when it's
+// clicked
+func Link(command string, text string) string {
+	return linkStart + EscapeLinks(command) + linkSeparator + EscapeLinks(text) + linkEnd
+}
+
+// EscapeLinks removes link markup from the given text, so that text which came
+// from a player can't open or close a link when it's shown next to one
+func EscapeLinks(text string) string {
+	return linkEscaper.Replace(text)
+}
+
+// processLinks turns links into MXP tags, escaping the rest of the text so
+// that it isn't mistaken for markup, or strips them when MXP isn't enabled
+func processLinks(text string, mxp bool) string {
+	if !mxp && !strings.Contains(text, linkStart) {
+		return text
+	}
+
+	var result []string
+
+	for {
+		start := strings.Index(text, linkStart)
+		if start == -1 {
+			break
+		}
+
+		separator := strings.Index(text[start:], linkSeparator)
+		end := strings.Index(text[start:], linkEnd)
+
+		if separator == -1 || end == -1 || separator > end {
+			break
+		}
+
+		separator += start
+		end += start
+
+		command := text[start+len(linkStart) : separator]
+		linkText := text[separator+len(linkSeparator) : end]
+
+		if mxp {
+			result = append(result, mxpEscaper.Replace(text[:start]),
+				mxpTempSecure+"<send href=\""+mxpEscaper.Replace(command)+"\">",
+				mxpEscaper.Replace(linkText),
+				mxpTempSecure+"</send>")
+		} else {
+			result = append(result, text[:start], linkText)
+		}
+
+		text = text[end+len(linkEnd):]
+	}
+
+	if mxp {
+		text = mxpEscaper.Replace(text)
+	}
+
+	result = append(result, text)
+	return strings.Join(result, "")
+}
+
+// StripMarkup removes all colors and links from the given text, leaving only
+// what would be displayed
+func StripMarkup(text string) string {
+	return StripColors(processLinks(text, false))
+}
+
+// vim: nocindent
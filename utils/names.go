@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Names from scripts other than Latin make it easy to register a name that
+// looks just like someone else's (e.g. "Аdmin" with a Cyrillic А). Names are
+// kept to a single script, and new names are compared to existing ones by
+// their skeleton: what they look like, rather than how they're spelled.
+
+// Scripts that are written together, and so may be mixed within a name
+var scriptGroups = map[string]string{
+	"Han":      "CJK",
+	"Hiragana": "CJK",
+	"Katakana": "CJK",
+	"Bopomofo": "CJK",
+	"Hangul":   "CJK",
+}
+
+// scriptOf returns the name of the script the rune belongs to, or of the group
+// of scripts it's written together with. Empty if it doesn't belong to one
+// (e.g. mathematical letters).
+func scriptOf(r rune) string {
+	for name, table := range unicode.Scripts {
+		if name == "Common" || name == "Inherited" {
+			continue
+		}
+
+		if unicode.Is(table, r) {
+			if group, found := scriptGroups[name]; found {
+				return group
+			}
+			return name
+		}
+	}
+
+	return ""
+}
+
+// foldWidth turns fullwidth forms of ASCII characters into ASCII, as Unicode
+// compatibility normalization (NFKC) would
+func foldWidth(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 0xFF01 && r <= 0xFF5E {
+			return r - 0xFEE0
+		}
+		return r
+	}, name)
+}
+
+// checkScripts returns false if the name mixes letters from different
+// scripts, or has letters that don't belong to any
+func checkScripts(name string) bool {
+	script := ""
+
+	for _, r := range name {
+		if r >= '0' && r <= '9' {
+			continue
+		}
+
+		s := scriptOf(r)
+
+		if s == "" || (script != "" && s != script) {
+			return false
+		}
+
+		script = s
+	}
+
+	return true
+}
+
+// Letters that look like Latin ones (after lowercasing), and the Latin letters
+// they're mistaken for
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'ԁ': 'd', 'е': 'e', 'һ': 'h', 'н': 'h', 'і': 'i', 'ј': 'j',
+	'к': 'k', 'ӏ': 'l', 'м': 'm', 'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'т': 't',
+	'у': 'y', 'ү': 'y', 'ԝ': 'w', 'х': 'x', 'с': 'c',
+
+	// Greek
+	'α': 'a', 'β': 'b', 'ϲ': 'c', 'ε': 'e', 'η': 'h', 'ι': 'i', 'κ': 'k', 'μ': 'm',
+	'ν': 'n', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'y', 'χ': 'x', 'ζ': 'z',
+
+	// Digits
+	'0': 'o', '1': 'l',
+}
+
+// NameSkeleton returns what the name looks like, so that names which could be
+// mistaken for one another have the same skeleton
+func NameSkeleton(name string) string {
+	return strings.Map(func(r rune) rune {
+		if c, found := confusables[r]; found {
+			return c
+		}
+		return r
+	}, Simplify(foldWidth(name)))
+}
+
+// vim: nocindent
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"testing"
+)
+
+func Test_ValidateNameScripts(t *testing.T) {
+	SetNamePolicy(NamePolicyUnicode)
+	defer SetNamePolicy(NamePolicyASCII)
+
+	var tests = []struct {
+		input  string
+		output bool
+	}{
+		{"Admin", true},
+		{"Аdmin", false}, // Cyrillic А
+		{"Админ", true},  // All Cyrillic
+		{"Ｂｏｂ", true},    // Fullwidth, same as Bob
+		{"名前です", true},
+		{"カタカナ名", true},
+		{"\U0001D400dmin", false}, // Mathematical bold A
+		{"Bob42", true},
+		{"Bob٤٢", false}, // Arabic-Indic digits
+	}
+
+	for _, test := range tests {
+		result := (ValidateName(test.input) == nil)
+		if result != test.output {
+			t.Errorf("ValidateName(%s) == %v, want %v", test.input, result, test.output)
+		}
+	}
+}
+
+func Test_NameSkeleton(t *testing.T) {
+	var tests = []struct {
+		name1 string
+		name2 string
+		same  bool
+	}{
+		{"Admin", "admin", true},
+		{"Admin", "Аdmin", true},
+		{"Admin", "Αdmin", true}, // Greek Α
+		{"Bob", "B0b", true},
+		{"Bob", "Ｂｏｂ", true},
+		{"Paul", "Pau1", true},
+		{"Bob", "Rob", false},
+	}
+
+	for _, test := range tests {
+		same := NameSkeleton(test.name1) == NameSkeleton(test.name2)
+		if same != test.same {
+			t.Errorf("NameSkeleton(%s) == NameSkeleton(%s): %v, want %v", test.name1, test.name2, same, test.same)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"strconv"
+	"strings"
+)
+
+// Password hashes are stored as
+//
+//	scrypt$<log2 N>$<r>$<p>$<salt>$<key>
+//
+// with the salt and key base64 encoded. The leading scheme name makes the
+// format versioned: hashes made with another scheme, or with older
+// parameters, are still verified and reported as outdated so that they can be
+// replaced on the next successful login.
+//
+// Hashes without a scheme are the original format, an unsalted SHA-1 digest.
+const passwordScheme = "scrypt"
+
+// Parameters used for new hashes, 2^15 iterations with r = 8 takes 32MB and
+// around a tenth of a second
+const (
+	scryptLogN    = 15
+	scryptR       = 8
+	scryptP       = 1
+	scryptKeyLen  = 32
+	scryptSaltLen = 16
+)
+
+// HashPassword returns the password hashed with the current scheme and a new
+// random salt
+func HashPassword(password string) []byte {
+	salt := make([]byte, scryptSaltLen)
+	_, err := rand.Read(salt)
+	PanicIfError(err)
+
+	key, err := scrypt.Key([]byte(password), salt, 1<<scryptLogN, scryptR, scryptP, scryptKeyLen)
+	PanicIfError(err)
+
+	return []byte(fmt.Sprintf("%s$%v$%v$%v$%s$%s", passwordScheme, scryptLogN, scryptR, scryptP,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)))
+}
+
+// CheckPassword returns true if the password matches the hash. outdated is
+// true when the password matched but the hash should be replaced by a new
+// one from HashPassword.
+func CheckPassword(hash []byte, password string) (match bool, outdated bool) {
+	if !bytes.HasPrefix(hash, []byte(passwordScheme+"$")) {
+		if len(hash) != sha1.Size {
+			return false, false
+		}
+
+		digest := sha1.Sum([]byte(password))
+		match = subtle.ConstantTimeCompare(digest[:], hash) == 1
+		return match, match
+	}
+
+	fields := strings.Split(string(hash), "$")
+
+	if len(fields) != 6 {
+		return false, false
+	}
+
+	var params [3]int
+	for i := range params {
+		param, err := strconv.Atoi(fields[i+1])
+
+		if err != nil || param < 1 {
+			return false, false
+		}
+
+		params[i] = param
+	}
+
+	logN, r, p := params[0], params[1], params[2]
+
+	if logN > 30 {
+		return false, false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return false, false
+	}
+
+	want, err := base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil || len(want) == 0 {
+		return false, false
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, 1<<uint(logN), r, p, len(want))
+	if err != nil {
+		return false, false
+	}
+
+	match = subtle.ConstantTimeCompare(key, want) == 1
+	outdated = logN != scryptLogN || r != scryptR || p != scryptP || len(want) != scryptKeyLen
+
+	return match, match && outdated
+}
+
+// Temporary passwords are made from letters and digits that can't be mistaken
+// for one another when read out or copied by hand
+const (
+	temporaryPasswordChars  = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	temporaryPasswordLength = 12
+)
+
+// TemporaryPassword returns a new random password, for giving to a user who
+// has to replace it with one of their own
+func TemporaryPassword() string {
+	// Bytes past the largest multiple of the alphabet's length are thrown
+	// away so that every character is equally likely
+	limit := 256 - 256%len(temporaryPasswordChars)
+
+	password := make([]byte, 0, temporaryPasswordLength)
+	buf := make([]byte, temporaryPasswordLength)
+
+	for len(password) < temporaryPasswordLength {
+		_, err := rand.Read(buf)
+		PanicIfError(err)
+
+		for _, b := range buf {
+			if int(b) < limit && len(password) < temporaryPasswordLength {
+				password = append(password, temporaryPasswordChars[int(b)%len(temporaryPasswordChars)])
+			}
+		}
+	}
+
+	return string(password)
+}
+
+// vim: nocindent
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"strings"
+	"testing"
+)
+
+func Test_HashPassword(t *testing.T) {
+	hash1 := HashPassword("hunter22")
+	hash2 := HashPassword("hunter22")
+
+	if !bytes.HasPrefix(hash1, []byte("scrypt$15$8$1$")) {
+		t.Errorf("HashPassword() == %q, want the scrypt scheme", hash1)
+	}
+
+	if bytes.Equal(hash1, hash2) {
+		t.Errorf("HashPassword() gave the same hash twice, the salt isn't random")
+	}
+
+	if match, outdated := CheckPassword(hash1, "hunter22"); !match || outdated {
+		t.Errorf("CheckPassword(HashPassword(p), p) == %v, %v, want true, false", match, outdated)
+	}
+
+	if match, _ := CheckPassword(hash1, "hunter23"); match {
+		t.Errorf("CheckPassword() accepted the wrong password")
+	}
+}
+
+func Test_CheckPassword(t *testing.T) {
+	legacy := sha1.Sum([]byte("hunter22"))
+
+	tests := []struct {
+		hash     []byte
+		password string
+		match    bool
+		outdated bool
+	}{
+		// Unsalted SHA-1, from before the hash format was versioned
+		{legacy[:], "hunter22", true, true},
+		{legacy[:], "hunter23", false, false},
+
+		// Weaker parameters than the current ones
+		{[]byte("scrypt$10$8$1$c2FsdHNhbHRzYWx0c2FsdA$oF6T4wq80HuRQsp4f+WYzuXIWcU9DH0SXCKtwyBn1gU"), "hunter22", true, true},
+		{[]byte("scrypt$10$8$1$c2FsdHNhbHRzYWx0c2FsdA$oF6T4wq80HuRQsp4f+WYzuXIWcU9DH0SXCKtwyBn1gU"), "hunter23", false, false},
+
+		{nil, "", false, false},
+		{[]byte("hunter22"), "hunter22", false, false},
+		{[]byte("bcrypt$10$8$1$c2FsdA$a2V5"), "hunter22", false, false},
+		{[]byte("scrypt$x$8$1$c2FsdA$a2V5"), "hunter22", false, false},
+		{[]byte("scrypt$10$8$1$c2FsdA"), "hunter22", false, false},
+		{[]byte("scrypt$40$8$1$c2FsdA$a2V5"), "hunter22", false, false},
+	}
+
+	for _, test := range tests {
+		match, outdated := CheckPassword(test.hash, test.password)
+
+		if match != test.match || outdated != test.outdated {
+			t.Errorf("CheckPassword(%q, %q) == %v, %v, want %v, %v", test.hash, test.password,
+				match, outdated, test.match, test.outdated)
+		}
+	}
+}
+
+func Test_TemporaryPassword(t *testing.T) {
+	seen := map[string]bool{}
+
+	for i := 0; i < 100; i++ {
+		password := TemporaryPassword()
+
+		if len(password) != temporaryPasswordLength {
+			t.Errorf("TemporaryPassword() == %q, want %v characters", password, temporaryPasswordLength)
+		}
+
+		for _, c := range password {
+			if !strings.ContainsRune(temporaryPasswordChars, c) {
+				t.Errorf("TemporaryPassword() == %q, %q isn't one of the allowed characters", password, c)
+			}
+		}
+
+		if seen[password] {
+			t.Errorf("TemporaryPassword() gave %q twice", password)
+		}
+
+		seen[password] = true
+	}
+}
+
+// vim: nocindent
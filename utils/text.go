@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Helpers for laying out UTF-8 text on a terminal, where the number of bytes
+// in a string has little to do with the number of columns it takes up
+
+// Ranges of East Asian wide and fullwidth characters, which take up two
+// columns on a terminal
+var wideRanges = []struct {
+	low, high rune
+}{
+	{0x1100, 0x115F},   // Hangul Jamo
+	{0x2E80, 0x303E},   // CJK radicals, Kangxi radicals, CJK symbols and punctuation
+	{0x3041, 0x33FF},   // Hiragana, Katakana, Bopomofo, CJK compatibility
+	{0x3400, 0x4DBF},   // CJK unified ideographs extension A
+	{0x4E00, 0x9FFF},   // CJK unified ideographs
+	{0xA000, 0xA4CF},   // Yi
+	{0xAC00, 0xD7A3},   // Hangul syllables
+	{0xF900, 0xFAFF},   // CJK compatibility ideographs
+	{0xFE30, 0xFE4F},   // CJK compatibility forms
+	{0xFF00, 0xFF60},   // Fullwidth forms
+	{0xFFE0, 0xFFE6},   // Fullwidth signs
+	{0x1F300, 0x1F64F}, // Miscellaneous symbols and pictographs, emoticons
+	{0x1F900, 0x1F9FF}, // Supplemental symbols and pictographs
+	{0x20000, 0x3FFFD}, // CJK unified ideographs extensions B and beyond
+}
+
+// RuneWidth returns the number of terminal columns taken up by the given rune
+func RuneWidth(r rune) int {
+	if r == 0 || unicode.IsControl(r) || unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+
+	for _, wide := range wideRanges {
+		if r >= wide.low && r <= wide.high {
+			return 2
+		}
+	}
+
+	return 1
+}
+
+// DisplayWidth returns the number of terminal columns taken up by the given
+// text. MUD color codes and link markup aren't counted.
+func DisplayWidth(text string) int {
+	width := 0
+
+	for _, r := range StripMarkup(text) {
+		width += RuneWidth(r)
+	}
+
+	return width
+}
+
+// StripControl removes C0 control characters other than tab from the given
+// text. Clients have no business sending them, and some of them mean
+// something to us (colors and links) or to the terminals of other players.
+func StripControl(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' && r != '\t' {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// ToValidUTF8 returns the given text unchanged if it's valid UTF-8. Otherwise
+// it's assumed to be Latin-1 (the most likely thing for a client that hasn't
+// negotiated a character set to send) and is converted.
+func ToValidUTF8(text string) string {
+	if utf8.ValidString(text) {
+		return text
+	}
+
+	runes := make([]rune, len(text))
+	for i := 0; i < len(text); i++ {
+		runes[i] = rune(text[i])
+	}
+
+	return string(runes)
+}
+
+// vim: nocindent
@@ -2,18 +2,19 @@ package utils
 
 import (
 	"bufio"
-	"bytes"
-	"compress/zlib"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math/rand"
+	"net"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
 type Prompter interface {
@@ -36,9 +37,28 @@ func SimplePrompter(prompt string) Prompter {
 }
 
 func Write(conn io.Writer, text string, cm ColorMode) (int, error) {
+	text = processLinks(text, mxpEnabled(conn))
 	return conn.Write([]byte(processColors(text, cm)))
 }
 
+// promptMarker is implemented by connections that are able to tell the client
+// where a prompt ends (e.g. telnet's IAC EOR or IAC GA)
+type promptMarker interface {
+	MarkPrompt()
+}
+
+// WritePrompt writes the given prompt, followed by an end of prompt marker if
+// the connection supports one
+func WritePrompt(conn io.Writer, prompt string, cm ColorMode) (int, error) {
+	n, err := Write(conn, prompt, cm)
+
+	if marker, ok := conn.(promptMarker); ok && err == nil {
+		marker.MarkPrompt()
+	}
+
+	return n, err
+}
+
 func WriteLine(conn io.Writer, line string, cm ColorMode) (int, error) {
 	return Write(conn, line+"\r\n", cm)
 }
@@ -64,15 +84,29 @@ func GetRawUserInputSuffixP(conn io.ReadWriter, prompter Prompter, suffix string
 	scanner := bufio.NewScanner(conn)
 
 	for {
-		Write(conn, prompter.GetPrompt(), cm)
+		prompt := prompter.GetPrompt()
+		WritePrompt(conn, prompt, cm)
 
-		if !scanner.Scan() {
-			panic("EOF")
-		}
+		var input string
 
-		PanicIfError(scanner.Err())
+		if editor := lineEditorFor(conn); editor != nil {
+			line, err := editor.ReadLine(conn, prompt, cm)
+
+			if err != nil {
+				panic("EOF")
+			}
+
+			input = StripControl(ToValidUTF8(line))
+		} else {
+			if !scanner.Scan() {
+				panic("EOF")
+			}
+
+			PanicIfError(scanner.Err())
+
+			input = StripControl(ToValidUTF8(scanner.Text()))
+		}
 
-		input := scanner.Text()
 		Write(conn, suffix, cm)
 
 		if input == "x" || input == "X" {
@@ -113,7 +147,7 @@ func FormatName(name string) string {
 		return name
 	}
 
-	runes := []rune(Simplify(name))
+	runes := []rune(Simplify(foldWidth(name)))
 	runes[0] = unicode.ToUpper(runes[0])
 	return string(runes)
 }
@@ -138,7 +172,7 @@ func Argify(data string) (string, []string) {
 }
 
 func rowEmpty(row string) bool {
-	for _, char := range row {
+	for _, char := range StripMarkup(row) {
 		if char != ' ' {
 			return false
 		}
@@ -175,12 +209,51 @@ func TrimEmptyRows(str string) string {
 	return strings.Join(TrimLowerRows(TrimUpperRows(rows)), "\r\n")
 }
 
+// NamePolicy controls which characters are allowed in user and character names
+type NamePolicy int
+
+const (
+	NamePolicyASCII   NamePolicy = iota // Only A-Z and 0-9
+	NamePolicyUnicode NamePolicy = iota // Letters and digits from any script
+)
+
+var namePolicy = NamePolicyASCII
+
+// SetNamePolicy changes the policy that ValidateName enforces
+func SetNamePolicy(policy NamePolicy) {
+	namePolicy = policy
+}
+
+var minNameLength = 3
+var maxNameLength = 12
+
+// SetNameLength changes the name length limits that ValidateName enforces
+func SetNameLength(min int, max int) {
+	minNameLength = min
+	maxNameLength = max
+}
+
 func ValidateName(name string) error {
-	const MinSize = 3
-	const MaxSize = 12
+	length := utf8.RuneCountInString(name)
 
-	if len(name) < MinSize || len(name) > MaxSize {
-		return errors.New(fmt.Sprintf("Names must be between %v and %v letters long", MinSize, MaxSize))
+	if length < minNameLength || length > maxNameLength {
+		return errors.New(fmt.Sprintf("Names must be between %v and %v letters long", minNameLength, maxNameLength))
+	}
+
+	if namePolicy == NamePolicyUnicode {
+		name = foldWidth(name)
+
+		for _, char := range name {
+			if !unicode.IsLetter(char) && !(char >= '0' && char <= '9') {
+				return errors.New("Names may only contain letters or numbers")
+			}
+		}
+
+		if !checkScripts(name) {
+			return errors.New("Names may not mix letters from different alphabets")
+		}
+
+		return nil
 	}
 
 	regex := regexp.MustCompile("^[a-zA-Z0-9]*$")
@@ -224,14 +297,6 @@ func BestMatch(pattern string, searchList []string) int {
 	return index
 }
 
-func compress(data []byte) []byte {
-	var b bytes.Buffer
-	w := zlib.NewWriter(&b)
-	w.Write(data)
-	w.Close()
-	return b.Bytes()
-}
-
 type WatchableReadWriter struct {
 	rw       io.ReadWriter
 	watchers []io.ReadWriter
@@ -297,6 +362,24 @@ func FindMethod(object interface{}, name string) (reflect.Value, bool) {
 	return reflect.Value{}, false
 }
 
+// MethodNames returns the lowercased names of all of the exported methods on
+// the given object, i.e. everything that FindMethod is able to find
+func MethodNames(object interface{}) []string {
+	objType := reflect.TypeOf(object)
+
+	var names []string
+
+	for i := 0; i < objType.NumMethod(); i++ {
+		method := objType.Method(i)
+
+		if method.PkgPath == "" {
+			names = append(names, strings.ToLower(method.Name))
+		}
+	}
+
+	return names
+}
+
 func FindAndCallMethod(object interface{}, name string, a ...interface{}) bool {
 	method, found := FindMethod(object, name)
 
@@ -359,4 +442,68 @@ func Random(low, high int) int {
 	return result
 }
 
+// ParseDuration is time.ParseDuration, with days and weeks added for the
+// longer spans that users tend to give, e.g. "3d" or "2w". "forever" is a
+// duration of zero.
+func ParseDuration(str string) (time.Duration, error) {
+	str = strings.ToLower(str)
+
+	if str == "forever" {
+		return 0, nil
+	}
+
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+
+	for suffix, unit := range units {
+		if strings.HasSuffix(str, suffix) {
+			count, err := strconv.Atoi(strings.TrimSuffix(str, suffix))
+
+			if err != nil || count <= 0 {
+				return 0, fmt.Errorf("Invalid duration: %s", str)
+			}
+
+			return time.Duration(count) * unit, nil
+		}
+	}
+
+	duration, err := time.ParseDuration(str)
+
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("Invalid duration: %s", str)
+	}
+
+	return duration, nil
+}
+
+// ParseAddressRange parses a range of addresses in CIDR notation, e.g.
+// 192.0.2.0/24, or a single address
+func ParseAddressRange(str string) (*net.IPNet, error) {
+	if !strings.Contains(str, "/") {
+		ip := net.ParseIP(str)
+
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid address: %s", str)
+		}
+
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 8 * net.IPv4len
+		}
+
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(str)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid address range: %s", str)
+	}
+
+	return network, nil
+}
+
 // vim: nocindent
@@ -8,6 +8,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test_WriteLine(t *testing.T) {
@@ -23,6 +24,63 @@ func Test_WriteLine(t *testing.T) {
 	}
 }
 
+type markingWriter struct {
+	testutils.TestWriter
+}
+
+func (self *markingWriter) MarkPrompt() {
+	self.Wrote += "<mark>"
+}
+
+func Test_WritePrompt(t *testing.T) {
+	writer := &testutils.TestWriter{}
+	WritePrompt(writer, "> ", ColorModeNone)
+
+	if writer.Wrote != "> " {
+		t.Errorf("WritePrompt() == %q, want %q", writer.Wrote, "> ")
+	}
+
+	marking := &markingWriter{}
+	WritePrompt(marking, "> ", ColorModeNone)
+
+	if marking.Wrote != "> <mark>" {
+		t.Errorf("WritePrompt() == %q, want %q", marking.Wrote, "> <mark>")
+	}
+}
+
+type mxpWriter struct {
+	testutils.TestWriter
+}
+
+func (self *mxpWriter) MXPEnabled() bool {
+	return true
+}
+
+func Test_Links(t *testing.T) {
+	text := "Exits: " + Link("n", "[N]orth") + " <" + Link("get ball & \"chain\"", "ball") + ">"
+
+	writer := &testutils.TestWriter{}
+	Write(writer, text, ColorModeNone)
+
+	if writer.Wrote != "Exits: [N]orth <ball>" {
+		t.Errorf("Write() without MXP == %q", writer.Wrote)
+	}
+
+	mxp := &mxpWriter{}
+	Write(mxp, text, ColorModeNone)
+
+	want := "Exits: \x1b[4z<send href=\"n\">[N]orth\x1b[4z</send> &lt;" +
+		"\x1b[4z<send href=\"get ball &amp; &quot;chain&quot;\">ball\x1b[4z</send>&gt;"
+
+	if mxp.Wrote != want {
+		t.Errorf("Write() with MXP == %q, want %q", mxp.Wrote, want)
+	}
+
+	if DisplayWidth(text) != len("Exits: [N]orth <ball>") {
+		t.Errorf("DisplayWidth(%q) == %v", text, DisplayWidth(text))
+	}
+}
+
 func Test_Simplify(t *testing.T) {
 	var tests = []struct {
 		s, want string
@@ -156,6 +214,106 @@ func Test_ValidateName(t *testing.T) {
 	}
 }
 
+func Test_ValidateNameUnicode(t *testing.T) {
+	SetNamePolicy(NamePolicyUnicode)
+	defer SetNamePolicy(NamePolicyASCII)
+
+	var tests = []struct {
+		input  string
+		output bool
+	}{
+		{"Zoë", true},
+		{"Jürgen", true},
+		{"名前です", true},
+		{"名前", false},
+		{"Ünïcödënämés", true},
+		{"Ünïcödënämész", false},
+		{"Zo ë", false},
+		{"Zoë!", false},
+	}
+
+	for _, test := range tests {
+		result := (ValidateName(test.input) == nil)
+		if result != test.output {
+			t.Errorf("ValidateName(%s) == %v, want %v", test.input, result, test.output)
+		}
+	}
+}
+
+func Test_DisplayWidth(t *testing.T) {
+	var tests = []struct {
+		input  string
+		output int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"caf\u00e9", 4},
+		{"cafe\u0301", 4},
+		{"\u4e16\u754c", 4},
+		{"@3blue##", 4},
+		{"a\tb", 2},
+	}
+
+	for _, test := range tests {
+		result := DisplayWidth(test.input)
+		if result != test.output {
+			t.Errorf("DisplayWidth(%q) == %v, want %v", test.input, result, test.output)
+		}
+	}
+}
+
+func Test_ToValidUTF8(t *testing.T) {
+	var tests = []struct {
+		input  string
+		output string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"caf\u00e9", "caf\u00e9"},
+		{"caf\xe9", "caf\u00e9"},
+	}
+
+	for _, test := range tests {
+		result := ToValidUTF8(test.input)
+		if result != test.output {
+			t.Errorf("ToValidUTF8(%q) == %q, want %q", test.input, result, test.output)
+		}
+	}
+}
+
+func Test_StripControl(t *testing.T) {
+	var tests = []struct {
+		input  string
+		output string
+	}{
+		{"", ""},
+		{"plain\ttext", "plain\ttext"},
+		{"\x02look\x1fme\x03", "lookme"},
+		{"\x1b[31mred", "[31mred"},
+	}
+
+	for _, test := range tests {
+		result := StripControl(test.input)
+		if result != test.output {
+			t.Errorf("StripControl(%q) == %q, want %q", test.input, result, test.output)
+		}
+	}
+}
+
+func Test_EscapeLinks(t *testing.T) {
+	name := "\x03\x02quit\x1fBob"
+	text := Link("look "+name, name) + " waves"
+
+	mxp := &mxpWriter{}
+	Write(mxp, text, ColorModeNone)
+
+	want := "\x1b[4z<send href=\"look quitBob\">quitBob\x1b[4z</send> waves"
+
+	if mxp.Wrote != want {
+		t.Errorf("Write() with MXP == %q, want %q", mxp.Wrote, want)
+	}
+}
+
 func Test_BestMatch(t *testing.T) {
 	searchList := []string{"", "Foo", "Bar", "Joe", "Bob", "Abcdef", "Abc", "QrStUv"}
 
@@ -394,4 +552,61 @@ func Test_Random(t *testing.T) {
 	}
 }
 
+func Test_ParseDuration(t *testing.T) {
+	var tests = []struct {
+		input  string
+		output time.Duration
+		valid  bool
+	}{
+		{"forever", 0, true},
+		{"Forever", 0, true},
+		{"30m", 30 * time.Minute, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"3d", 72 * time.Hour, true},
+		{"2w", 14 * 24 * time.Hour, true},
+		{"", 0, false},
+		{"0", 0, false},
+		{"-1h", 0, false},
+		{"0d", 0, false},
+		{"xd", 0, false},
+		{"soon", 0, false},
+	}
+
+	for _, test := range tests {
+		output, err := ParseDuration(test.input)
+
+		if (err == nil) != test.valid || output != test.output {
+			t.Errorf("ParseDuration(%q) == %v, %v", test.input, output, err)
+		}
+	}
+}
+
+func Test_ParseAddressRange(t *testing.T) {
+	var tests = []struct {
+		input  string
+		output string
+	}{
+		{"192.0.2.7", "192.0.2.7/32"},
+		{"192.0.2.7/24", "192.0.2.0/24"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"2001:db8::/32", "2001:db8::/32"},
+		{"192.0.2", ""},
+		{"192.0.2.0/33", ""},
+		{"example.com", ""},
+	}
+
+	for _, test := range tests {
+		network, err := ParseAddressRange(test.input)
+
+		output := ""
+		if err == nil {
+			output = network.String()
+		}
+
+		if output != test.output {
+			t.Errorf("ParseAddressRange(%q) == %q, %v, want %q", test.input, output, err, test.output)
+		}
+	}
+}
+
 // vim:nocindent
@@ -0,0 +1,307 @@
+// Package websocket implements just enough of the WebSocket protocol (RFC
+// 6455, http://tools.ietf.org/html/rfc6455) to carry a telnet session to a
+// browser. A WebSocket connection is presented as a net.Conn whose byte stream
+// is the payload of the data frames, frame boundaries aren't preserved.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Appended to the client's key to produce the accept key, as per the RFC
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Frames larger than this are refused, nothing a player types comes close
+const maxFrameSize = 1 << 20
+
+type opcode byte
+
+const (
+	opContinuation opcode = 0x0
+	opText         opcode = 0x1
+	opBinary       opcode = 0x2
+	opClose        opcode = 0x8
+	opPing         opcode = 0x9
+	opPong         opcode = 0xA
+)
+
+var ErrFrameTooLarge = errors.New("websocket: frame too large")
+var ErrUnmaskedFrame = errors.New("websocket: client frame isn't masked")
+
+// Conn is a WebSocket connection that has completed its handshake
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	writeMutex sync.Mutex
+
+	// Unread payload of the current data frame
+	remaining int64
+	mask      [4]byte
+	maskIndex int
+
+	closed bool
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for the given
+// Sec-WebSocket-Key
+func AcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func headerContains(header http.Header, name string, value string) bool {
+	for _, field := range strings.Split(header.Get(name), ",") {
+		if strings.EqualFold(strings.TrimSpace(field), value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameOrigin returns true if the request comes from a page served by the host
+// it was sent to, or from a client that isn't a browser and sends no origin.
+// Browsers let any page open a WebSocket to any host, so without this any site
+// a player visits could play in their name.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// Upgrade completes the WebSocket handshake for the given request, taking over
+// its connection. An error response has already been sent if it fails.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != "GET" || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("websocket: not an upgrade request")
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusBadRequest)
+		return nil, errors.New("websocket: unsupported version")
+	}
+
+	if !sameOrigin(r) {
+		http.Error(w, "Cross-origin WebSocket connections aren't allowed", http.StatusForbidden)
+		return nil, errors.New("websocket: origin not allowed")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("websocket: missing key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket upgrade not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response can't be hijacked")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return newConn(conn, rw.Reader), nil
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader) *Conn {
+	var c Conn
+	c.conn = conn
+	c.reader = reader
+	return &c
+}
+
+// Read reads the payload of the client's data frames. Control frames are
+// handled as they arrive, io.EOF is returned once the client closes the
+// connection.
+func (self *Conn) Read(p []byte) (int, error) {
+	for self.remaining == 0 {
+		if self.closed {
+			return 0, io.EOF
+		}
+
+		if err := self.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > self.remaining {
+		p = p[:self.remaining]
+	}
+
+	n, err := self.reader.Read(p)
+
+	for i := 0; i < n; i++ {
+		p[i] ^= self.mask[self.maskIndex]
+		self.maskIndex = (self.maskIndex + 1) % 4
+	}
+
+	self.remaining -= int64(n)
+	return n, err
+}
+
+// nextFrame reads the next frame header, dealing with control frames
+// completely. When it returns without an error for a data frame the payload
+// is ready to be read.
+func (self *Conn) nextFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(self.reader, header[:]); err != nil {
+		return err
+	}
+
+	op := opcode(header[0] & 0x0F)
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(self.reader, extended[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(self.reader, extended[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(extended[:]))
+	}
+
+	if !masked {
+		return ErrUnmaskedFrame
+	}
+
+	if length > maxFrameSize || length < 0 {
+		return ErrFrameTooLarge
+	}
+
+	if _, err := io.ReadFull(self.reader, self.mask[:]); err != nil {
+		return err
+	}
+
+	self.maskIndex = 0
+
+	switch op {
+	case opContinuation, opText, opBinary:
+		self.remaining = length
+		return nil
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(self.reader, payload); err != nil {
+		return err
+	}
+
+	for i := range payload {
+		payload[i] ^= self.mask[i%4]
+	}
+
+	switch op {
+	case opPing:
+		return self.writeFrame(opPong, payload)
+	case opClose:
+		self.closed = true
+		self.writeFrame(opClose, payload)
+		return io.EOF
+	}
+
+	// Pongs and unknown control frames are ignored
+	return nil
+}
+
+// Write sends the data to the client as a single binary frame
+func (self *Conn) Write(p []byte) (int, error) {
+	if err := self.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (self *Conn) writeFrame(op opcode, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|byte(op)) // FIN
+
+	length := len(payload)
+
+	switch {
+	case length < 126:
+		frame = append(frame, byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, 126, byte(length>>8), byte(length))
+	default:
+		var extended [8]byte
+		binary.BigEndian.PutUint64(extended[:], uint64(length))
+		frame = append(frame, 127)
+		frame = append(frame, extended[:]...)
+	}
+
+	frame = append(frame, payload...)
+
+	self.writeMutex.Lock()
+	defer self.writeMutex.Unlock()
+
+	_, err := self.conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame and closes the underlying connection
+func (self *Conn) Close() error {
+	self.writeFrame(opClose, []byte{0x03, 0xE8}) // 1000, normal closure
+	return self.conn.Close()
+}
+
+func (self *Conn) LocalAddr() net.Addr {
+	return self.conn.LocalAddr()
+}
+
+func (self *Conn) RemoteAddr() net.Addr {
+	return self.conn.RemoteAddr()
+}
+
+func (self *Conn) SetDeadline(t time.Time) error {
+	return self.conn.SetDeadline(t)
+}
+
+func (self *Conn) SetReadDeadline(t time.Time) error {
+	return self.conn.SetReadDeadline(t)
+}
+
+func (self *Conn) SetWriteDeadline(t time.Time) error {
+	return self.conn.SetWriteDeadline(t)
+}
+
+// vim: nocindent
@@ -0,0 +1,257 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_AcceptKey(t *testing.T) {
+	// Example from section 1.3 of the RFC
+	if key := AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); key != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("AcceptKey() == %s, want s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", key)
+	}
+}
+
+// clientFrame builds a masked frame, as a browser would send it
+func clientFrame(op opcode, fin bool, payload []byte) []byte {
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+
+	first := byte(op)
+	if fin {
+		first |= 0x80
+	}
+
+	frame := []byte{first}
+
+	if len(payload) < 126 {
+		frame = append(frame, 0x80|byte(len(payload)))
+	} else {
+		frame = append(frame, 0x80|126, byte(len(payload)>>8), byte(len(payload)))
+	}
+
+	frame = append(frame, mask...)
+
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	return frame
+}
+
+func pipeConn(input []byte) (*Conn, net.Conn) {
+	server, client := net.Pipe()
+	conn := newConn(server, bufio.NewReader(bytes.NewReader(input)))
+	return conn, client
+}
+
+func Test_Read(t *testing.T) {
+	long := strings.Repeat("x", 300)
+
+	var input []byte
+	input = append(input, clientFrame(opBinary, false, []byte("Hel"))...)
+	input = append(input, clientFrame(opContinuation, true, []byte("lo\r\n"))...)
+	input = append(input, clientFrame(opText, true, []byte(long))...)
+
+	conn, client := pipeConn(input)
+	defer client.Close()
+
+	data, err := readUntilError(conn)
+
+	if err != io.ErrUnexpectedEOF && err != io.EOF {
+		t.Errorf("Read() at the end of the input == %v, want EOF", err)
+	}
+
+	if string(data) != "Hello\r\n"+long {
+		t.Errorf("Read() == %q, want %q", data, "Hello\r\n"+long)
+	}
+}
+
+// readUntilError reads until the first error, which is returned along with the
+// data (unlike ioutil.ReadAll, which hides EOF)
+func readUntilError(r io.Reader) ([]byte, error) {
+	var data []byte
+	buf := make([]byte, 64)
+
+	for {
+		n, err := r.Read(buf)
+		data = append(data, buf[:n]...)
+
+		if err != nil {
+			return data, err
+		}
+	}
+}
+
+func Test_PingClose(t *testing.T) {
+	var input []byte
+	input = append(input, clientFrame(opPing, true, []byte("abc"))...)
+	input = append(input, clientFrame(opText, true, []byte("x"))...)
+	input = append(input, clientFrame(opClose, true, []byte{0x03, 0xE8})...)
+
+	conn, client := pipeConn(input)
+	defer client.Close()
+
+	replies := make(chan []byte)
+	go func() {
+		buf := make([]byte, 64)
+		var all []byte
+		for i := 0; i < 2; i++ {
+			n, _ := client.Read(buf)
+			all = append(all, buf[:n]...)
+		}
+		replies <- all
+	}()
+
+	data, err := readUntilError(conn)
+
+	if string(data) != "x" || err != io.EOF {
+		t.Errorf("Read() == %q, %v, want \"x\", EOF", data, err)
+	}
+
+	want := []byte{0x8A, 3, 'a', 'b', 'c', 0x88, 2, 0x03, 0xE8}
+	if got := <-replies; !bytes.Equal(got, want) {
+		t.Errorf("Replies to ping and close == %v, want %v", got, want)
+	}
+}
+
+func Test_Unmasked(t *testing.T) {
+	conn, client := pipeConn([]byte{0x82, 0x01, 'x'})
+	defer client.Close()
+
+	if _, err := conn.Read(make([]byte, 10)); err != ErrUnmaskedFrame {
+		t.Errorf("Read() of an unmasked frame == %v, want %v", err, ErrUnmaskedFrame)
+	}
+}
+
+func Test_Write(t *testing.T) {
+	conn, client := pipeConn(nil)
+
+	tests := []struct {
+		payload []byte
+		header  []byte
+	}{
+		{[]byte("hi"), []byte{0x82, 2}},
+		{bytes.Repeat([]byte("y"), 200), []byte{0x82, 126, 0, 200}},
+		{bytes.Repeat([]byte("z"), 70000), []byte{0x82, 127, 0, 0, 0, 0, 0, 1, 0x11, 0x70}},
+	}
+
+	for _, test := range tests {
+		go conn.Write(test.payload)
+
+		frame := make([]byte, len(test.header)+len(test.payload))
+		if _, err := io.ReadFull(client, frame); err != nil {
+			t.Fatalf("Reading frame failed: %v", err)
+		}
+
+		if !bytes.Equal(frame[:len(test.header)], test.header) || !bytes.Equal(frame[len(test.header):], test.payload) {
+			t.Errorf("Write(%d bytes) header == %v, want %v", len(test.payload), frame[:len(test.header)], test.header)
+		}
+	}
+
+	client.Close()
+}
+
+func Test_Upgrade(t *testing.T) {
+	upgraded := make(chan *Conn, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			upgraded <- nil
+			return
+		}
+		upgraded <- conn
+	}))
+	defer server.Close()
+
+	if resp, err := http.Get(server.URL); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Plain GET should be refused with 400, got %v, %v", resp, err)
+	}
+	<-upgraded
+
+	client, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	io.WriteString(client, "GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\n"+
+		"Connection: keep-alive, Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+
+	reader := bufio.NewReader(client)
+	resp, err := http.ReadResponse(reader, nil)
+
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Handshake response == %v, %v, want 101", resp, err)
+	}
+
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept == %s", accept)
+	}
+
+	conn := <-upgraded
+	if conn == nil {
+		t.Fatalf("Upgrade() failed")
+	}
+	defer conn.Close()
+
+	client.Write(clientFrame(opBinary, true, []byte("look")))
+
+	buf := make([]byte, 10)
+	n, err := conn.Read(buf)
+
+	if string(buf[:n]) != "look" || err != nil {
+		t.Errorf("Read() after upgrade == %q, %v, want \"look\"", buf[:n], err)
+	}
+}
+
+func Test_Origin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := Upgrade(w, r); err == nil {
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	var tests = []struct {
+		origin string
+		status int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"http://localhost", http.StatusSwitchingProtocols},
+		{"http://evil.example", http.StatusForbidden},
+		{"http://localhost.evil.example", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		client, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+
+		origin := ""
+		if test.origin != "" {
+			origin = "Origin: " + test.origin + "\r\n"
+		}
+
+		io.WriteString(client, "GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\n"+
+			"Connection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+			"Sec-WebSocket-Version: 13\r\n"+origin+"\r\n")
+
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+
+		if err != nil || resp.StatusCode != test.status {
+			t.Errorf("Upgrade with origin %q == %v, %v, want %v", test.origin, resp, err, test.status)
+		}
+
+		client.Close()
+	}
+}
+